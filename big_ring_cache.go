@@ -3,10 +3,14 @@ package cache
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -14,22 +18,48 @@ import (
 	xxhash "github.com/cespare/xxhash/v2"
 )
 
-var (
-	HomeDir                string = ""
-	FileName               string = "big-cache-ring-data.txt"
-	DeleteKeyFileDirectory string = "delete-key-dir"
-	DeleteKeyFilePrefix    string = "delete-key-file"
-	DeleteKeyFile          string = ""
+const (
+	defaultFileName               = "big-cache-ring-data.txt"
+	defaultDeleteKeyFileDirectory = "delete-key-dir"
+	defaultDeleteKeyFilePrefix    = "delete-key-file"
 )
 
-type bigCacheRing struct {
+// BigCacheRingOptions is the configuration of one bigCacheRing. Every instance has its own files, so two
+// instances with different Dir or different FileName never touch each other's data. The temp file and the
+// delete key directory are named after FileName when they are empty, two instances that set them must set
+// different ones.
+type BigCacheRingOptions struct {
+	/** number of keys kept in memory, the file has no limit */
+	BufferSize int32
+	/** directory of the data file and of the delete key directory, empty means the home directory of the user */
+	Dir      string
+	FileName string
+	/** default is FileName with -temp before the extension */
+	TempFileName string
+	/** default is FileName without the extension and with -delete-key-dir, delete-key-dir for the default FileName */
+	DeleteKeyFileDirectory string
+	DeleteKeyFilePrefix    string
+	/** when the deleted keys are removed from the data file */
+	Ticker *TickerInfo
+}
+
+// BigCache save every item in a file and keep the recently read items in memory. The values are encoded with
+// the codec, so any type can be saved. Every item is one line "key value" in the file, the encoded value is
+// saved in base64 so it never break the line, only the values of StringCodec are saved as they are.
+type BigCache[T any] struct {
 	file        *os.File
 	offsetMap   map[uint64]int64
-	cacheRing   *cacheRing[string]
+	cacheRing   *cacheRing[T]
 	bloomFilter *bbloom.Bloom
 	deleteInfo  *deleteInfo
+	stats       ringStats
+	opts        BigCacheRingOptions
+	codec       Codec[T]
 }
 
+/** bigCacheRing is the BigCache of string values, the first version of the cache */
+type bigCacheRing = BigCache[string]
+
 type TickerInfo struct {
 	Hour     int
 	Min      int
@@ -37,86 +67,241 @@ type TickerInfo struct {
 	Interval time.Duration
 }
 
+// withDefaults return the options with the empty fields set to their default.
+func (o BigCacheRingOptions) withDefaults() (BigCacheRingOptions, error) {
+	if o.Dir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return o, err
+		}
+		o.Dir = homeDir
+	}
+	if o.FileName == "" {
+		o.FileName = defaultFileName
+	}
+	ext := filepath.Ext(o.FileName)
+	name := strings.TrimSuffix(o.FileName, ext)
+	if o.TempFileName == "" {
+		o.TempFileName = name + "-temp" + ext
+	}
+	if o.DeleteKeyFileDirectory == "" {
+		/** the default file keep the directory it always had, so the deleted keys of old files are still found */
+		o.DeleteKeyFileDirectory = defaultDeleteKeyFileDirectory
+		if o.FileName != defaultFileName {
+			o.DeleteKeyFileDirectory = name + "-" + defaultDeleteKeyFileDirectory
+		}
+	}
+	if o.TempFileName == o.FileName || o.DeleteKeyFileDirectory == o.FileName {
+		return o, errors.New("TempFileName and DeleteKeyFileDirectory must differ from FileName")
+	}
+	if o.DeleteKeyFilePrefix == "" {
+		o.DeleteKeyFilePrefix = defaultDeleteKeyFilePrefix
+	}
+	if o.Ticker == nil {
+		o.Ticker = &TickerInfo{Interval: 24 * time.Hour}
+	}
+	return o, nil
+}
+
+func (o BigCacheRingOptions) filePath() string {
+	return fmt.Sprintf("%v/%v", o.Dir, o.FileName)
+}
+
+func (o BigCacheRingOptions) tempFilePath() string {
+	return fmt.Sprintf("%v/%v", o.Dir, o.TempFileName)
+}
+
+func (o BigCacheRingOptions) deleteKeyDir() string {
+	return fmt.Sprintf("%v/%v", o.Dir, o.DeleteKeyFileDirectory)
+}
+
 type CleanFileInterface interface {
 	updateCleanedFile(map[uint64]int64, []string)
 }
 
-func NewBigCacheRing(bufferSize int32, ti *TickerInfo) (*bigCacheRing, error) {
-	var err error = nil
-	HomeDir, err = os.UserHomeDir()
+func NewBigCacheRing(opts *BigCacheRingOptions) (*bigCacheRing, error) {
+	return NewBigCache[string](opts, StringCodec{})
+}
+
+// NewBigCache is the BigCache of values of type T, nil codec means GobCodec.
+func NewBigCache[T any](opts *BigCacheRingOptions, codec Codec[T]) (*BigCache[T], error) {
+	if codec == nil {
+		codec = GobCodec[T]{}
+	}
+	o, err := opts.withDefaults()
 	if err != nil {
 		return nil, errors.New("failed to create file")
 	}
-	filePath := fmt.Sprintf("%v/%v", HomeDir, FileName)
-	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	file, err := os.OpenFile(o.filePath(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
 		return nil, err
 	}
-	deleteFileDirErr := os.Mkdir(HomeDir+"/"+DeleteKeyFileDirectory, 0744)
+	deleteFileDirErr := os.Mkdir(o.deleteKeyDir(), 0744)
 	if deleteFileDirErr != nil && !os.IsExist(deleteFileDirErr) {
 		return nil, errors.New("failed to create directory that contain delete key files")
 	}
-	cacheRing := NewCacheRing[string](bufferSize)
+	cacheRing := NewCacheRing[T](o.BufferSize, RingSieve)
 	offsetMap := make(map[uint64]int64)
 	filter := bbloom.NewBloomFilter(1000000, 0.01)
-	di, deleteFileInfoErr := newDeleteInfo(ti)
+	di, deleteFileInfoErr := newDeleteInfo(o)
 	if deleteFileInfoErr != nil {
 		fmt.Printf("error in delete info initialization %v \n", deleteFileInfoErr)
 		return nil, errors.New("error is delete info initialization")
 	}
-	bigch := &bigCacheRing{
+	bigch := &BigCache[T]{
 		file:        file,
 		offsetMap:   offsetMap,
 		cacheRing:   cacheRing,
 		bloomFilter: filter,
 		deleteInfo:  di,
+		opts:        o,
+		codec:       codec,
 	}
 	go di.process(bigch)
 	return bigch, nil
 }
 
-func (c *bigCacheRing) Set(key string, value string) error {
+// encode return the value as it is saved in the file.
+func (c *BigCache[T]) encode(value T) (string, error) {
+	data, err := c.codec.Encode(value)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := c.codec.(lineCodec); ok {
+		return string(data), nil
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func (c *BigCache[T]) decode(line []byte) (T, error) {
+	if _, ok := c.codec.(lineCodec); ok {
+		return c.codec.Decode(line)
+	}
+	data, err := base64.StdEncoding.DecodeString(string(line))
+	if err != nil {
+		var value T
+		return value, err
+	}
+	return c.codec.Decode(data)
+}
+
+func (c *BigCache[T]) Set(key string, value T) error {
 	keyByte := []byte(key)
 	keyInt := xxhash.Sum64(keyByte)
+	encoded, err := c.encode(value)
+	if err != nil {
+		return err
+	}
 	offset, err := c.file.Seek(0, io.SeekEnd)
 	if err != nil {
 		fmt.Printf("big cache error seeking the file")
 		return err
 	}
-	fileData := key + " " + value + "\n"
+	fileData := key + " " + encoded + "\n"
 	_, saveErr := c.file.WriteString(fileData)
 	if saveErr != nil {
 		return saveErr
 	}
 	c.offsetMap[keyInt] = offset
+	c.stats.setSize(len(c.offsetMap))
 	c.bloomFilter.Add(keyInt)
 	return nil
 }
 
-func (c *bigCacheRing) Get(key string) (string, error) {
+// SetMany save all the items with one write to the file.
+func (c *BigCache[T]) SetMany(items map[string]T) error {
+	encoded := make(map[string]string, len(items))
+	for key, value := range items {
+		data, err := c.encode(value)
+		if err != nil {
+			return err
+		}
+		encoded[key] = data
+	}
+	offset, err := c.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	var fileData strings.Builder
+	offsets := make(map[uint64]int64, len(items))
+	for key, value := range encoded {
+		offsets[xxhash.Sum64([]byte(key))] = offset + int64(fileData.Len())
+		fileData.WriteString(key + " " + value + "\n")
+	}
+	if _, saveErr := c.file.WriteString(fileData.String()); saveErr != nil {
+		return saveErr
+	}
+	for keyInt, keyOffset := range offsets {
+		c.offsetMap[keyInt] = keyOffset
+		c.bloomFilter.Add(keyInt)
+	}
+	c.stats.setSize(len(c.offsetMap))
+	return nil
+}
+
+// GetMany return the values of the keys that are found, missing keys are not in the map. Keys not in memory
+// are read from the file in the order of their offset.
+func (c *BigCache[T]) GetMany(keys []string) map[string]T {
+	values := make(map[string]T, len(keys))
+	fileKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if value, err := c.cacheRing.Get(key); err == nil {
+			c.stats.hit()
+			values[key] = value
+		} else {
+			fileKeys = append(fileKeys, key)
+		}
+	}
+	sort.Slice(fileKeys, func(i, j int) bool {
+		return c.offsetMap[xxhash.Sum64([]byte(fileKeys[i]))] < c.offsetMap[xxhash.Sum64([]byte(fileKeys[j]))]
+	})
+	for _, key := range fileKeys {
+		if value, err := c.Get(key); err == nil {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+func (c *BigCache[T]) Get(key string) (T, error) {
+	return c.get(context.Background(), key)
+}
+
+func (c *BigCache[T]) get(ctx context.Context, key string) (T, error) {
+	var zero T
+	if ctx.Err() != nil {
+		return zero, context.Cause(ctx)
+	}
 	keyByte := []byte(key)
 	keyInt := xxhash.Sum64(keyByte)
 	itemValue, fetchErr := c.cacheRing.Get(key)
 	if fetchErr == nil {
+		c.stats.hit()
 		return itemValue, nil
 	} else {
 		if !c.bloomFilter.Has(keyInt) {
-			return "", errors.New("key not found")
+			c.stats.miss()
+			return zero, errors.New("key not found")
 		}
 		offset, ok := c.offsetMap[keyInt]
 		if ok {
 			valueOffset := offset + int64(len(key)) + 1
 			_, fileErr := c.file.Seek(valueOffset, 0)
 			if fileErr != nil {
-				return "", fileErr
+				return zero, fileErr
 			}
 			buffer := make([]byte, 1)
 			var content []byte
 
 			for {
+				select {
+				case <-ctx.Done():
+					return zero, context.Cause(ctx)
+				default:
+				}
 				n, err := c.file.Read(buffer)
 				if err != nil {
-					return "", err
+					return zero, err
 				}
 
 				if n == 0 {
@@ -129,25 +314,32 @@ func (c *bigCacheRing) Get(key string) (string, error) {
 
 				content = append(content, buffer[0])
 			}
-			value := string(content)
+			value, decodeErr := c.decode(content)
+			if decodeErr != nil {
+				return zero, decodeErr
+			}
 			c.cacheRing.Set(key, value)
-			return string(content), nil
+			c.stats.hit()
+			return value, nil
 		} else {
-			return "", errors.New("key not found")
+			c.stats.miss()
+			return zero, errors.New("key not found")
 		}
 	}
 
 }
 
-func (c *bigCacheRing) Delete(key string) {
+func (c *BigCache[T]) Delete(key string) {
 	keyByte := []byte(key)
 	keyInt := xxhash.Sum64(keyByte)
-	delete(c.cacheRing.data, keyInt)
+	c.cacheRing.Delete(key)
 	delete(c.offsetMap, keyInt)
+	c.stats.setSize(len(c.offsetMap))
 	c.deleteInfo.add(key)
+	c.stats.addRemoval(RemovalDeleted)
 }
 
-func (c *bigCacheRing) Size(cacheType int) int {
+func (c *BigCache[T]) Size(cacheType int) int {
 	if cacheType == 1 {
 		return len(c.offsetMap)
 	} else {
@@ -170,7 +362,7 @@ func splitFunction(data []byte, eof bool) (next int, token []byte, err error) {
 	return 0, nil, nil
 }
 
-func (c *bigCacheRing) loadFileOffset(done chan int) {
+func (c *BigCache[T]) loadFileOffset(done chan int) {
 	scanner := bufio.NewScanner(c.file)
 	scanner.Split(splitFunction)
 	offset := int64(0)
@@ -189,7 +381,8 @@ func (c *bigCacheRing) loadFileOffset(done chan int) {
 		}
 		offset += int64(len(b))
 	}
-	tempFilePath = fmt.Sprintf("%v/%v", HomeDir, "big-cache-ring-data-temp.txt")
+	c.stats.setSize(len(c.offsetMap))
+	tempFilePath := c.opts.tempFilePath()
 	_, err := os.Stat(tempFilePath)
 	if err == nil {
 		os.Remove(tempFilePath)
@@ -198,20 +391,20 @@ func (c *bigCacheRing) loadFileOffset(done chan int) {
 	done <- 1
 }
 
-func (c *bigCacheRing) LoadFileOffset(done chan int) {
+func (c *BigCache[T]) LoadFileOffset(done chan int) {
 	go c.loadFileOffset(done)
 }
 
-func (c *bigCacheRing) updateCleanedFile(offsetMap map[uint64]int64, keys []string) {
+func (c *BigCache[T]) updateCleanedFile(offsetMap map[uint64]int64, keys []string) {
 	if len(keys) > 0 {
 		c.file.Close()
-		filePath := fmt.Sprintf("%v/%v", HomeDir, FileName)
-		file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+		file, err := os.OpenFile(c.opts.filePath(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
 		if err != nil {
 			fmt.Printf("failed to open cleaned file %v \n", err)
 		}
 		c.file = file
 		c.offsetMap = offsetMap
+		c.stats.setSize(len(c.offsetMap))
 		c.bloomFilter.Clear()
 		for _, key := range keys {
 			keyByte := []byte(key)
@@ -221,9 +414,10 @@ func (c *bigCacheRing) updateCleanedFile(offsetMap map[uint64]int64, keys []stri
 	}
 }
 
-func (c *bigCacheRing) Clear() {
+func (c *BigCache[T]) Clear() {
 	c.file.Close()
 	c.deleteInfo.clear()
 	c.bloomFilter.Clear()
 	c.offsetMap = make(map[uint64]int64)
+	c.stats.setSize(0)
 }
@@ -45,7 +45,7 @@ func BenchmarkBigCache(b *testing.B) {
 		Min:      05,
 		Sec:      10,
 	}
-	bgc, err := NewBigCacheRing(100000, ti)
+	bgc, err := NewBigCacheRing(&BigCacheRingOptions{BufferSize: 100000, Ticker: ti})
 	if err == nil {
 		saveData(bgc, 0, 800000)
 		Delete(bgc, 0, 2000)
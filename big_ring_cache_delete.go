@@ -11,13 +11,6 @@ import (
 	"github.com/cespare/xxhash/v2"
 )
 
-var (
-	bucketNo      = 1
-	tempFilePath  = ""
-	mainFilePath  = ""
-	oldDeleteFile *os.File
-)
-
 type bucket map[string]byte
 type deleteInfo struct {
 	tempFile       *os.File
@@ -28,6 +21,9 @@ type deleteInfo struct {
 	buckets        map[int]bucket
 	t              *time.Timer
 	deleteKeyFile  *os.File
+	oldDeleteFile  *os.File
+	bucketNo       int
+	opts           BigCacheRingOptions
 }
 
 func getTickerTime(dInt time.Duration, dHour int, dMin int, dSec int) time.Duration {
@@ -39,13 +35,14 @@ func getTickerTime(dInt time.Duration, dHour int, dMin int, dSec int) time.Durat
 	return time.Until(nextTick)
 }
 
-func createDeleteFile() (*os.File, error) {
-	deleteFileName := fmt.Sprintf("%v-%v.txt", DeleteKeyFilePrefix, time.Now().UnixMilli())
-	deleteFilePath := fmt.Sprintf("%v/%v/%v", HomeDir, DeleteKeyFileDirectory, deleteFileName)
+func (d *deleteInfo) createDeleteFile() (*os.File, error) {
+	deleteFileName := fmt.Sprintf("%v-%v.txt", d.opts.DeleteKeyFilePrefix, time.Now().UnixMilli())
+	deleteFilePath := fmt.Sprintf("%v/%v", d.opts.deleteKeyDir(), deleteFileName)
 	return os.OpenFile(deleteFilePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
 }
 
-func newDeleteInfo(ti *TickerInfo) (*deleteInfo, error) {
+func newDeleteInfo(opts BigCacheRingOptions) (*deleteInfo, error) {
+	ti := opts.Ticker
 	di := &deleteInfo{
 		deleteHour:     ti.Hour,
 		deleteInterval: ti.Interval,
@@ -53,21 +50,21 @@ func newDeleteInfo(ti *TickerInfo) (*deleteInfo, error) {
 		deleteSec:      ti.Sec,
 		buckets:        make(map[int]bucket),
 		t:              time.NewTimer(getTickerTime(ti.Interval, ti.Hour, ti.Min, ti.Sec)),
+		bucketNo:       1,
+		opts:           opts,
 	}
-	tempFilePath = fmt.Sprintf("%v/%v", HomeDir, "big-cache-ring-data-temp.txt")
-	mainFilePath = fmt.Sprintf("%v/%v", HomeDir, FileName)
-	deleteFile, err := createDeleteFile()
+	deleteFile, err := di.createDeleteFile()
 	di.deleteKeyFile = deleteFile
 	return di, err
 }
 
 func (d *deleteInfo) loadDeleteKeys() {
-	b, ok := d.buckets[bucketNo]
+	b, ok := d.buckets[d.bucketNo]
 	if !ok {
 		b = make(bucket)
-		d.buckets[bucketNo] = b
+		d.buckets[d.bucketNo] = b
 	}
-	delDir := fmt.Sprintf("%v/%v", HomeDir, DeleteKeyFileDirectory)
+	delDir := d.opts.deleteKeyDir()
 	files, err := os.ReadDir(delDir)
 	if err == nil && len(files) > 1 {
 		deleteFileName := files[1]
@@ -87,10 +84,10 @@ func (d *deleteInfo) loadDeleteKeys() {
 }
 
 func (d *deleteInfo) add(key string) {
-	b, ok := d.buckets[bucketNo]
+	b, ok := d.buckets[d.bucketNo]
 	if !ok {
 		b = make(bucket)
-		d.buckets[bucketNo] = b
+		d.buckets[d.bucketNo] = b
 	}
 	b[key] = byte(1)
 	_, seekErr := d.deleteKeyFile.Seek(0, io.SeekEnd)
@@ -115,21 +112,21 @@ func (d *deleteInfo) updateTicker() {
 func (d *deleteInfo) cleanFile() (map[uint64]int64, []string) {
 	offsetMap := make(map[uint64]int64)
 	keys := make([]string, 10)
-	oldDeleteFile = d.deleteKeyFile
-	delBucketNo := bucketNo
-	bucketNo += 1
+	d.oldDeleteFile = d.deleteKeyFile
+	delBucketNo := d.bucketNo
+	d.bucketNo += 1
 	bucket, ok := d.buckets[delBucketNo]
-	deleteKeyNewFile, deleteKeyNewFileErr := createDeleteFile()
+	deleteKeyNewFile, deleteKeyNewFileErr := d.createDeleteFile()
 	if deleteKeyNewFileErr == nil {
 		d.deleteKeyFile = deleteKeyNewFile
 	}
 	if ok {
-		file, fileErr := mainFile(mainFilePath)
+		file, fileErr := mainFile(d.opts.filePath())
 		if fileErr != nil {
 			fmt.Printf("main file create error %v \n", fileErr)
 			return nil, nil
 		}
-		tmpFile, tmpFileErr := createTempFile(tempFilePath)
+		tmpFile, tmpFileErr := createTempFile(d.opts.tempFilePath())
 		d.tempFile = tmpFile
 		if tmpFileErr != nil {
 			fmt.Printf("temp file create error %v \n", tmpFileErr)
@@ -169,17 +166,17 @@ func (d *deleteInfo) process(intrf CleanFileInterface) {
 		<-d.t.C
 		offsetMap, keys := d.cleanFile()
 		if offsetMap != nil {
-			renameErr := os.Rename(tempFilePath, mainFilePath)
+			renameErr := os.Rename(d.opts.tempFilePath(), d.opts.filePath())
 			if renameErr != nil {
 				fmt.Printf("failed to rename the temp file after cleanup %v \n", renameErr)
 			} else {
 				intrf.updateCleanedFile(offsetMap, keys)
 			}
 		}
-		delete(d.buckets, bucketNo-1)
+		delete(d.buckets, d.bucketNo-1)
 		d.tempFile.Close()
-		oldDeleteFile.Close()
-		delDir := fmt.Sprintf("%v/%v", HomeDir, DeleteKeyFileDirectory)
+		d.oldDeleteFile.Close()
+		delDir := d.opts.deleteKeyDir()
 		files, err := os.ReadDir(delDir)
 		if err == nil {
 			for i := 0; i < len(files)-1; i++ {
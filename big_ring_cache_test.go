@@ -2,6 +2,7 @@ package cache
 
 import (
 	"fmt"
+	"os"
 	"testing"
 	"time"
 )
@@ -30,7 +31,7 @@ func TestBigRingCacheDelete(t *testing.T) {
 		Min:      minute,
 		Sec:      second,
 	}
-	bch, initErr := NewBigCacheRing(5, ti)
+	bch, initErr := NewBigCacheRing(&BigCacheRingOptions{BufferSize: 5, Ticker: ti})
 	if initErr != nil {
 		t.Errorf("failed to init cache %v \n", initErr)
 	}
@@ -47,7 +48,7 @@ func TestBigRingCacheDelete(t *testing.T) {
 	saveData(bch, 500010, 2000000)
 	/** clean data and init. This to test that the deleted keys are removed from file. */
 	bch.Clear()
-	bch, initErr = NewBigCacheRing(5, ti)
+	bch, initErr = NewBigCacheRing(&BigCacheRingOptions{BufferSize: 5, Ticker: ti})
 	if initErr != nil {
 		t.Errorf("failed to init cache %v \n", initErr)
 	}
@@ -85,7 +86,7 @@ func TestBigRingCache(t *testing.T) {
 		Min:      minute,
 		Sec:      second,
 	}
-	bch, initErr := NewBigCacheRing(5, ti)
+	bch, initErr := NewBigCacheRing(&BigCacheRingOptions{BufferSize: 5, Ticker: ti})
 	if initErr != nil {
 		t.Errorf("failed to init cache %v \n", initErr)
 	}
@@ -132,3 +133,136 @@ func TestBigRingCache(t *testing.T) {
 		t.Fatalf("key3 is deleted so no value should be present")
 	}
 }
+
+func TestBigRingCacheBatch(t *testing.T) {
+	ti := &TickerInfo{
+		Interval: 24 * time.Hour,
+		Hour:     time.Now().Add(12 * time.Hour).Hour(),
+	}
+	bch, initErr := NewBigCacheRing(&BigCacheRingOptions{BufferSize: 5, Dir: t.TempDir(), Ticker: ti})
+	if initErr != nil {
+		t.Fatalf("failed to init cache %v \n", initErr)
+	}
+	defer bch.Clear()
+	items := make(map[string]string)
+	keys := make([]string, 0, 20)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("batch-%v-%v", keyPref, i)
+		items[key] = fmt.Sprintf("batch-%v-%v", valuePref, i)
+		keys = append(keys, key)
+	}
+	if err := bch.SetMany(items); err != nil {
+		t.Fatalf("failed to save items %v \n", err)
+	}
+	values := bch.GetMany(append(keys, "batch-missing"))
+	if len(values) != len(items) {
+		t.Fatalf("expected %v values, got %v", len(items), len(values))
+	}
+	for key, value := range items {
+		if values[key] != value {
+			t.Fatalf("wrong value %v for key %v", values[key], key)
+		}
+	}
+}
+
+func TestBigRingCacheInstances(t *testing.T) {
+	ti := &TickerInfo{
+		Interval: 24 * time.Hour,
+		Hour:     time.Now().Add(12 * time.Hour).Hour(),
+	}
+	dirA, dirB := t.TempDir(), t.TempDir()
+	a, err := NewBigCacheRing(&BigCacheRingOptions{BufferSize: 5, Dir: dirA, Ticker: ti})
+	if err != nil {
+		t.Fatalf("failed to init cache %v \n", err)
+	}
+	b, err := NewBigCacheRing(&BigCacheRingOptions{BufferSize: 5, Dir: dirB, Ticker: ti})
+	if err != nil {
+		t.Fatalf("failed to init cache %v \n", err)
+	}
+	a.Set("key", "value-a")
+	b.Set("key", "value-b")
+	b.Set("only-b", "value")
+
+	if value, err := a.Get("key"); err != nil || value != "value-a" {
+		t.Fatalf("expected value-a, got %v %v", value, err)
+	}
+	if _, err := a.Get("only-b"); err == nil {
+		t.Fatalf("key of the other instance should not be found")
+	}
+	/** every instance write only to the file in its own dir */
+	data, err := os.ReadFile(fmt.Sprintf("%v/%v", dirA, defaultFileName))
+	if err != nil || string(data) != "key value-a\n" {
+		t.Fatalf("file of a should have only its key, got %q %v", data, err)
+	}
+	if value, err := b.Get("key"); err != nil || value != "value-b" {
+		t.Fatalf("expected value-b, got %v %v", value, err)
+	}
+
+	/** an instance in the same dir with another FileName get its own temp file and delete key directory */
+	c, err := NewBigCacheRing(&BigCacheRingOptions{BufferSize: 5, Dir: dirA, FileName: "other.txt", Ticker: ti})
+	if err != nil {
+		t.Fatalf("failed to init cache %v \n", err)
+	}
+	if a.opts.tempFilePath() == c.opts.tempFilePath() || a.opts.deleteKeyDir() == c.opts.deleteKeyDir() {
+		t.Fatalf("instances share files %+v %+v", a.opts, c.opts)
+	}
+	if c.opts.TempFileName != "other-temp.txt" || c.opts.DeleteKeyFileDirectory != "other-delete-key-dir" {
+		t.Fatalf("unexpected default names %+v", c.opts)
+	}
+	c.Set("key", "value-c")
+	data, err = os.ReadFile(fmt.Sprintf("%v/%v", dirA, "other.txt"))
+	if err != nil || string(data) != "key value-c\n" {
+		t.Fatalf("file of c should have only its key, got %q %v", data, err)
+	}
+	if _, err := NewBigCacheRing(&BigCacheRingOptions{Dir: dirA, FileName: "same.txt", TempFileName: "same.txt"}); err == nil {
+		t.Fatalf("temp file same as the data file should be rejected")
+	}
+	a.Clear()
+	b.Clear()
+	c.Clear()
+}
+
+type bigCacheRecord struct {
+	Name  string
+	Count int
+}
+
+func TestBigCacheCodecs(t *testing.T) {
+	ti := &TickerInfo{
+		Interval: 24 * time.Hour,
+		Hour:     time.Now().Add(12 * time.Hour).Hour(),
+	}
+	/** the new line in the value is safe because the encoded value is saved in base64 */
+	record := bigCacheRecord{Name: "two\nlines with space", Count: 3}
+	for name, codec := range map[string]Codec[bigCacheRecord]{
+		"gob":  nil,
+		"json": JSONCodec[bigCacheRecord]{},
+	} {
+		bc, err := NewBigCache[bigCacheRecord](&BigCacheRingOptions{BufferSize: 5, Dir: t.TempDir(), Ticker: ti}, codec)
+		if err != nil {
+			t.Fatalf("failed to init %v cache %v \n", name, err)
+		}
+		bc.Set("first", record)
+		bc.SetMany(map[string]bigCacheRecord{"second": {Name: "second"}})
+		for i := 0; i < 2; i++ {
+			if value, err := bc.Get("first"); err != nil || value != record {
+				t.Fatalf("%v codec returned %v %v", name, value, err)
+			}
+		}
+		if values := bc.GetMany([]string{"second", "missing"}); len(values) != 1 || values["second"].Name != "second" {
+			t.Fatalf("%v codec returned %v for GetMany", name, values)
+		}
+		bc.Clear()
+	}
+
+	tc, err := NewBigCache[time.Time](&BigCacheRingOptions{BufferSize: 5, Dir: t.TempDir(), Ticker: ti}, BinaryCodec[time.Time, *time.Time]{})
+	if err != nil {
+		t.Fatalf("failed to init cache %v \n", err)
+	}
+	now := time.Now().Round(0)
+	tc.Set("now", now)
+	if value, err := tc.Get("now"); err != nil || !value.Equal(now) {
+		t.Fatalf("binary codec returned %v %v", value, err)
+	}
+	tc.Clear()
+}
@@ -1,39 +1,154 @@
 package cache
 
 import (
+	"errors"
+	"fmt"
 	"time"
-
-	"github.com/cespare/xxhash/v2"
 )
 
-var (
-	ExpirationInterval = int64(5)
-)
+/** default tick of the expiration wheel and of the cleanup */
+const defaultExpirationResolution = 5 * time.Second
 
-type CacheConfig struct {
+type CacheConfig[K comparable, V any] struct {
 	Capacity   uint64
 	CountBatch uint64
-	FreqCount  uint64
+	/** Deprecated: FreqCount is not used, the sketch is sized from SketchError and SketchConfidence. */
+	FreqCount uint64
+	/** SketchError is how much the frequency sketch can over count a key, as a fraction of the accesses counted
+	since the last aging, with probability SketchConfidence. Smaller error need a wider sketch and higher
+	confidence a deeper one. Zero error means one counter per key of Capacity, zero confidence means 0.99. */
+	SketchError      float64
+	SketchConfidence float64
+	/** MaxCost is the total cost the cache can hold. Zero means only Capacity bound the cache.
+	Item cost is given in SetWithCost, otherwise Cost is used and if Cost is nil every item cost 1. */
+	MaxCost int64
+	Cost    func(V) int64
+	/** OnEvict is called with every item that leave the cache and the reason it left. It run in a separate
+	goroutine, so it can be slow without holding up the cache. */
+	OnEvict func(key K, value V, reason RemovalReason)
+	/** DefaultTTL is used when the ttl is zero, if it is also zero the item never expire. MaxTTL cap the ttl
+	of every item that expire, items set with NoExpiration are not capped. TTLJitter is the fraction (0 to 1)
+	of the ttl that can be randomly cut from it. */
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
+	TTLJitter  float64
+	/** StaleWindow is how long an expired item is still returned by Get. The first read in the window start
+	a refresh with Loader in background, GetWithStale tells if the returned value is stale. */
+	StaleWindow time.Duration
+	Loader      func(key K) (V, error)
+	/** RefreshAfter reload the item in background with Loader on the first read after the item is older than
+	RefreshAfter. Readers get the current value till the reload finish, a failed reload keep the current value. */
+	RefreshAfter time.Duration
+	/** When set, a failed GetOrLoad is remembered for this long and returned to later callers
+	without calling the loader again. Zero means loader errors are never cached. */
+	LoadErrorTTL time.Duration
+	/** Policy pick the keys to evict, nil means the W-TinyLFU policy of NewLFUPolicy. */
+	Policy EvictionPolicy[K]
+	/** KeyHash hash the key for the shard and the policy, nil means the default hash of the key type. */
+	KeyHash func(K) uint64
+	/** ExpirationResolution is the tick of the expiration wheel and of the cleanup of this cache, zero means
+	5 seconds. MaxExpirePerTick bound the keys removed by one cleanup, the rest are removed by the next cleanup. */
+	ExpirationResolution time.Duration
+	MaxExpirePerTick     int
+	/** Shards is the number of maps the keys are split in, it is rounded up to a power of two. Zero means 256.
+	Small caches need only a few shards, many cores reading in parallel contend less with more shards. */
+	Shards int
+	/** KeyCodec and ValueCodec encode the items in the snapshot, nil means GobCodec. SnapshotFrequencies save the
+	frequencies of the policy with the items. When SnapshotPath is set the cache is restored from the file at
+	the start and saved to it on Close. OnSnapshotError get the error of that restore or save, nil means the
+	error is printed. A failed restore leave the cache empty. */
+	KeyCodec            Codec[K]
+	ValueCodec          Codec[V]
+	SnapshotFrequencies bool
+	SnapshotPath        string
+	OnSnapshotError     func(err error)
 }
 
-type Cache[T any] struct {
-	data          cacheOp[T]
+// KeyCache is the cache for any comparable key type. The key is only hashed to pick the shard and to
+// count the frequency, the original key is what we store and compare so two keys with the same hash
+// never see each other's value.
+type KeyCache[K comparable, V any] struct {
+	data          cacheOp[K, V]
 	done          chan int
 	cleanupTicker *time.Ticker
+	loads         *loadGroup[K, V]
+	hash          func(K) uint64
+	cost          func(V) int64
+	maxCost       int64
+	ttl           ttlPolicy
+	loader        func(K) (V, error)
+	refreshAfter  time.Duration
+	stats         keyCacheStats
+	keyCodec      Codec[K]
+	valueCodec    Codec[V]
+	snapshotFreq  bool
+	snapshotPath  string
+	snapshotErr   func(error)
+}
+
+// Cache is the KeyCache with string key.
+type Cache[T any] struct {
+	*KeyCache[string, T]
 }
 
-func NewCacheWithCapacity[T any](cConfig *CacheConfig, done chan int) *Cache[T] {
-	timer := time.NewTicker(time.Duration(ExpirationInterval) * time.Second)
-	cache := &Cache[T]{
-		data:          NewCacheData[T](cConfig, done),
+func NewCacheWithCapacity[T any](cConfig *CacheConfig[string, T], done chan int) *Cache[T] {
+	return &Cache[T]{
+		KeyCache: NewKeyCacheWithCapacity[string, T](cConfig, done),
+	}
+}
+
+func NewKeyCacheWithCapacity[K comparable, V any](cConfig *CacheConfig[K, V], done chan int) *KeyCache[K, V] {
+	interval := cConfig.ExpirationResolution
+	if interval <= 0 {
+		interval = defaultExpirationResolution
+	}
+	timer := time.NewTicker(interval)
+	hash := cConfig.KeyHash
+	if hash == nil {
+		hash = keyHash[K]
+	}
+	cache := &KeyCache[K, V]{
+		data:          NewCacheData[K, V](cConfig, done),
 		done:          done,
 		cleanupTicker: timer,
+		loads:         newLoadGroup[K, V](cConfig.LoadErrorTTL),
+		hash:          hash,
+		cost:          cConfig.Cost,
+		maxCost:       cConfig.MaxCost,
+		ttl: ttlPolicy{
+			defaultTTL: cConfig.DefaultTTL,
+			maxTTL:     cConfig.MaxTTL,
+			jitter:     cConfig.TTLJitter,
+		},
+		loader:       cConfig.Loader,
+		refreshAfter: cConfig.RefreshAfter,
+		keyCodec:     cConfig.KeyCodec,
+		valueCodec:   cConfig.ValueCodec,
+		snapshotFreq: cConfig.SnapshotFrequencies,
+		snapshotPath: cConfig.SnapshotPath,
+		snapshotErr:  cConfig.OnSnapshotError,
+	}
+	if cache.keyCodec == nil {
+		cache.keyCodec = GobCodec[K]{}
+	}
+	if cache.valueCodec == nil {
+		cache.valueCodec = GobCodec[V]{}
+	}
+	if cache.snapshotErr == nil {
+		cache.snapshotErr = func(err error) {
+			fmt.Printf("cache snapshot error %v \n", err)
+		}
+	}
+	if cache.snapshotPath != "" {
+		if err := cache.restoreFile(cache.snapshotPath); err != nil {
+			cache.snapshotErr(fmt.Errorf("failed to restore the cache snapshot: %w", err))
+		}
 	}
 	go cache.cleanUp()
 	return cache
 }
 
-func (c *Cache[T]) cleanUp() {
+func (c *KeyCache[K, V]) cleanUp() {
 	for {
 		select {
 		case <-c.cleanupTicker.C:
@@ -44,30 +159,112 @@ func (c *Cache[T]) cleanUp() {
 	}
 }
 
-func (c *Cache[T]) Close() {
+// Close stop the background work of the cache. When SnapshotPath is set the cache is saved first.
+func (c *KeyCache[K, V]) Close() {
+	if c.snapshotPath != "" {
+		if err := c.snapshotFile(c.snapshotPath); err != nil {
+			c.snapshotErr(fmt.Errorf("failed to save the cache snapshot: %w", err))
+		}
+	}
 	close(c.done)
 }
 
-func (c *Cache[T]) Reset() {
+func (c *KeyCache[K, V]) Reset() {
 	c.data.Reset()
 }
 
-func (c *Cache[T]) Set(key string, value T, ttl time.Duration) error {
-	expiration := time.Now().Add(ttl)
-	keyByte := []byte(key)
-	keyInt := xxhash.Sum64(keyByte)
-	c.data.Set(keyInt, value, expiration)
+func (c *KeyCache[K, V]) itemCost(value V) int64 {
+	if c.cost != nil {
+		return c.cost(value)
+	}
+	return 1
+}
+
+func (c *KeyCache[K, V]) checkCost(cost int64) error {
+	if cost < 0 {
+		return errors.New("item cost can not be negative")
+	}
+	if c.maxCost > 0 && cost > c.maxCost {
+		return errors.New("item cost is more than max cost")
+	}
+	return nil
+}
+
+func (c *KeyCache[K, V]) Set(key K, value V, ttl time.Duration) error {
+	return c.SetWithCost(key, value, c.itemCost(value), ttl)
+}
+
+func (c *KeyCache[K, V]) SetWithCost(key K, value V, cost int64, ttl time.Duration) error {
+	if err := c.checkCost(cost); err != nil {
+		return err
+	}
+	c.data.Set(key, c.hash(key), c.newItem(value, cost, 0, ttl, time.Now()))
+	c.loads.forget(key)
+	return nil
+}
+
+// SetWithIdle set the item with sliding expiration. The item expire when it is not read for idle duration,
+// every Get extend it. If maxTTL is more than zero the item also expire maxTTL after the set even if it
+// is read all the time, MaxTTL and TTLJitter of the config apply to it.
+func (c *KeyCache[K, V]) SetWithIdle(key K, value V, idle time.Duration, maxTTL time.Duration) error {
+	if idle <= 0 {
+		return errors.New("idle duration must be more than zero")
+	}
+	cost := c.itemCost(value)
+	if err := c.checkCost(cost); err != nil {
+		return err
+	}
+	c.data.Set(key, c.hash(key), c.newItem(value, cost, idle, maxTTL, time.Now()))
+	c.loads.forget(key)
 	return nil
 }
 
-func (c *Cache[T]) Get(key string) (T, error) {
-	keyByte := []byte(key)
-	keyInt := xxhash.Sum64(keyByte)
-	return c.data.Get(keyInt)
+// newItem create the item of a set. An item with idle duration has no ttl unless ttl is more than zero,
+// an item without idle duration get the default ttl for DefaultExpiration.
+func (c *KeyCache[K, V]) newItem(value V, cost int64, idle time.Duration, ttl time.Duration, now time.Time) *cacheItem[V] {
+	item := &cacheItem[V]{
+		item:    value,
+		cost:    cost,
+		idle:    idle,
+		ttl:     ttl,
+		written: now,
+	}
+	if idle > 0 {
+		item.lastAccess = now.UnixNano()
+	}
+	if idle <= 0 || ttl > 0 {
+		item.expiration = c.ttl.expiration(now, ttl)
+	}
+	return item
+}
+
+func (c *KeyCache[K, V]) Get(key K) (V, error) {
+	return c.get(key, c.loader)
+}
+
+// GetWithStale is Get that also tells if the value is stale, that is expired but still in the stale window.
+func (c *KeyCache[K, V]) GetWithStale(key K) (V, bool, error) {
+	return c.getWithStale(key, c.loader)
+}
+
+func (c *KeyCache[K, V]) get(key K, loader func(K) (V, error)) (V, error) {
+	value, _, err := c.getWithStale(key, loader)
+	return value, err
+}
+
+func (c *KeyCache[K, V]) getWithStale(key K, loader func(K) (V, error)) (V, bool, error) {
+	item, stale, err := c.data.Get(key, c.hash(key))
+	if err != nil {
+		var value V
+		return value, false, err
+	}
+	if stale || c.needRefresh(item, time.Now()) {
+		c.refresh(key, item, loader)
+	}
+	return item.item, stale, nil
 }
 
-func (c *Cache[T]) Delete(key string) {
-	keyByte := []byte(key)
-	keyInt := xxhash.Sum64(keyByte)
-	c.data.Del(keyInt)
+func (c *KeyCache[K, V]) Delete(key K) {
+	c.data.Del(key, c.hash(key))
+	c.loads.forget(key)
 }
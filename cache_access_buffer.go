@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"math/bits"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+)
+
+// accessBuffer collect the reads for process() without any shared slice, the idea is from the ring buffer
+// of Ristretto. The buffer own a fixed number of stripes, a few per P, and every read go to a random stripe
+// so parallel reads almost never touch the same stripe. A read that find its stripe locked by another
+// reader is dropped instead of waiting. A full stripe is handed to consume, and if consume can not take it
+// the reads are dropped. The frequencies are only an estimate, so losing some reads under pressure is
+// better than blocking Get.
+type accessBuffer[K comparable] struct {
+	stripes []accessStripe[K]
+	mask    uint32
+	size    int
+	consume func([]keyAccess[K]) bool
+}
+
+type accessStripe[K comparable] struct {
+	sync.Mutex
+	items []keyAccess[K]
+}
+
+func newAccessBuffer[K comparable](size uint64, consume func([]keyAccess[K]) bool) *accessBuffer[K] {
+	size = max(size, 1)
+	count := 1 << bits.Len(uint(4*runtime.GOMAXPROCS(0)-1))
+	b := &accessBuffer[K]{
+		stripes: make([]accessStripe[K], count),
+		mask:    uint32(count - 1),
+		size:    int(size),
+		consume: consume,
+	}
+	for i := range b.stripes {
+		b.stripes[i].items = make([]keyAccess[K], 0, size)
+	}
+	return b
+}
+
+func (b *accessBuffer[K]) push(key K, hash uint64) {
+	s := &b.stripes[rand.Uint32()&b.mask]
+	if !s.TryLock() {
+		return
+	}
+	defer s.Unlock()
+	s.items = append(s.items, keyAccess[K]{key: key, hash: hash})
+	if len(s.items) >= b.size {
+		if b.consume(s.items) {
+			s.items = make([]keyAccess[K], 0, b.size)
+		} else {
+			s.items = s.items[:0]
+		}
+	}
+}
+
+// drain hand the reads of every stripe to apply, also the stripes that are not full.
+func (b *accessBuffer[K]) drain(apply func([]keyAccess[K])) {
+	for i := range b.stripes {
+		s := &b.stripes[i]
+		s.Lock()
+		items := s.items
+		s.items = make([]keyAccess[K], 0, b.size)
+		s.Unlock()
+		if len(items) > 0 {
+			apply(items)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"container/list"
+)
+
+// ARC (Adaptive Replacement Cache). T1 has the keys read once and T2 the keys read again, B1 and B2 are the
+// ghost lists with only the keys recently evicted from T1 and T2. A new key found in B1 means T1 was too
+// small, so the target size p of T1 grow, a key found in B2 shrink it. This way the policy move between
+// recency and frequency with the workload and no counter need to be reset.
+type arcPolicy[K comparable] struct {
+	capacity int
+	p        int
+	t1       *list.List
+	t2       *list.List
+	b1       *list.List
+	b2       *list.List
+	items    map[K]*arcItem[K]
+	/** the last insert was found in B2, the victim is taken from T2 if T1 is at its target size */
+	insertFromB2 bool
+}
+
+type arcItem[K comparable] struct {
+	list    *list.List
+	element *list.Element
+}
+
+// NewARCPolicy is the ARC policy for a cache of capacity keys. The capacity bound the ghost lists and the
+// target size of T1, so it should be the Capacity of the cache.
+func NewARCPolicy[K comparable](capacity uint64) EvictionPolicy[K] {
+	return &arcPolicy[K]{
+		capacity: max(1, int(capacity)),
+		t1:       list.New(),
+		t2:       list.New(),
+		b1:       list.New(),
+		b2:       list.New(),
+		items:    make(map[K]*arcItem[K]),
+	}
+}
+
+func (p *arcPolicy[K]) move(key K, to *list.List) {
+	if item, ok := p.items[key]; ok {
+		item.list.Remove(item.element)
+	}
+	p.items[key] = &arcItem[K]{list: to, element: to.PushFront(key)}
+}
+
+func (p *arcPolicy[K]) removeLast(l *list.List) (K, bool) {
+	e := l.Back()
+	if e == nil {
+		var key K
+		return key, false
+	}
+	key := l.Remove(e).(K)
+	delete(p.items, key)
+	return key, true
+}
+
+func (p *arcPolicy[K]) OnAccess(key K, hash uint64) {
+	if item, ok := p.items[key]; ok && (item.list == p.t1 || item.list == p.t2) {
+		p.move(key, p.t2)
+	}
+}
+
+func (p *arcPolicy[K]) OnInsert(key K, hash uint64, cost int64) {
+	p.insertFromB2 = false
+	item, ok := p.items[key]
+	switch {
+	case ok && item.list == p.b1:
+		p.p = min(p.capacity, p.p+max(p.b2.Len()/p.b1.Len(), 1))
+		p.move(key, p.t2)
+	case ok && item.list == p.b2:
+		p.p = max(0, p.p-max(p.b1.Len()/p.b2.Len(), 1))
+		p.insertFromB2 = true
+		p.move(key, p.t2)
+	case ok:
+		p.move(key, p.t2)
+	default:
+		p.move(key, p.t1)
+	}
+	/** keep T1+B1 within the capacity and all the lists within twice the capacity */
+	if p.t1.Len()+p.b1.Len() > p.capacity {
+		p.removeLast(p.b1)
+	}
+	if p.t1.Len()+p.t2.Len()+p.b1.Len()+p.b2.Len() > 2*p.capacity {
+		p.removeLast(p.b2)
+	}
+}
+
+func (p *arcPolicy[K]) OnRemove(key K) {
+	if item, ok := p.items[key]; ok {
+		item.list.Remove(item.element)
+		delete(p.items, key)
+	}
+}
+
+// Victim evict from T1 when it is over its target size, otherwise from T2. The evicted key is kept in the
+// ghost list of its list.
+func (p *arcPolicy[K]) Victim() (K, bool) {
+	from, ghost := p.t2, p.b2
+	t1 := p.t1.Len()
+	if t1 > 0 && (t1 > p.p || (p.insertFromB2 && t1 == p.p) || p.t2.Len() == 0) {
+		from, ghost = p.t1, p.b1
+	}
+	key, ok := p.removeLast(from)
+	if ok {
+		p.items[key] = &arcItem[K]{list: ghost, element: ghost.PushFront(key)}
+	}
+	return key, ok
+}
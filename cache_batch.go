@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type batchItem[K comparable, T any] struct {
+	key  K
+	hash uint64
+	item *cacheItem[T]
+}
+
+/** Group the positions of the keys by shard, so every shard lock is taken only once for the whole batch */
+func (c *CacheData[K, T]) groupByShard(hashes []uint64) map[uint64][]int {
+	shards := make(map[uint64][]int)
+	for pos, hash := range hashes {
+		i := c.shardIndex(hash)
+		shards[i] = append(shards[i], pos)
+	}
+	return shards
+}
+
+func (c *CacheData[K, T]) SetMany(items []batchItem[K, T]) {
+	hashes := make([]uint64, len(items))
+	for pos, item := range items {
+		hashes[pos] = item.hash
+	}
+	oldItems := make([]*cacheItem[T], len(items))
+	updates := make([]bool, len(items))
+	for i, positions := range c.groupByShard(hashes) {
+		shard := c.data[i]
+		shard.Lock()
+		for _, pos := range positions {
+			oldItems[pos], updates[pos] = shard.dataMap[items[pos].key]
+			shard.dataMap[items[pos].key] = items[pos].item
+		}
+		shard.Unlock()
+	}
+
+	accesses := make([]keyAccess[K], 0, len(items))
+	for pos, item := range items {
+		if updates[pos] {
+			c.expirationData.add(item.key, item.hash, c.removeAt(item.item))
+			c.changeCost(item.item.cost - oldItems[pos].cost)
+			c.removed(item.key, oldItems[pos].item, RemovalReplaced)
+		} else {
+			c.expirationData.add(item.key, item.hash, c.removeAt(item.item))
+			accesses = append(accesses, keyAccess[K]{key: item.key, hash: item.hash})
+			c.changeSize(1)
+			c.changeCost(item.item.cost)
+		}
+	}
+	c.addFreqBatch(accesses)
+	c.Lock()
+	for pos, item := range items {
+		if !updates[pos] {
+			c.policy.OnInsert(item.key, item.hash, item.item.cost)
+		}
+	}
+	c.Unlock()
+	c.removeExcessItem()
+}
+
+// GetMany return the items of the keys that are found and which of them are stale.
+func (c *CacheData[K, T]) GetMany(keys []K, hashes []uint64) (map[K]*cacheItem[T], map[K]bool) {
+	items := make(map[K]*cacheItem[T], len(keys))
+	staleKeys := make(map[K]bool)
+	now := time.Now()
+	for i, positions := range c.groupByShard(hashes) {
+		shard := c.data[i]
+		shard.RLock()
+		for _, pos := range positions {
+			item, ok := shard.dataMap[keys[pos]]
+			if !ok {
+				atomic.AddUint64(&shard.stats.misses, 1)
+				continue
+			}
+			stale := item.expired(now)
+			if stale && (c.staleWindow <= 0 || item.expired(now.Add(-c.staleWindow))) {
+				atomic.AddUint64(&shard.stats.misses, 1)
+				atomic.AddUint64(&shard.stats.expiredOnRead, 1)
+				continue
+			}
+			if stale {
+				atomic.AddUint64(&shard.stats.staleHits, 1)
+				staleKeys[keys[pos]] = true
+			} else {
+				item.touch(now)
+			}
+			atomic.AddUint64(&shard.stats.hits, 1)
+			items[keys[pos]] = item
+		}
+		shard.RUnlock()
+	}
+	accesses := make([]keyAccess[K], len(keys))
+	for pos, key := range keys {
+		accesses[pos] = keyAccess[K]{key: key, hash: hashes[pos]}
+	}
+	c.addFreqBatch(accesses)
+	return items, staleKeys
+}
+
+func (c *CacheData[K, T]) DelMany(keys []K, hashes []uint64) {
+	c.Lock()
+	for _, key := range keys {
+		c.removeKey(key)
+	}
+	c.Unlock()
+	for i, positions := range c.groupByShard(hashes) {
+		shard := c.data[i]
+		removed := make([]batchItem[K, T], 0, len(positions))
+		shard.Lock()
+		for _, pos := range positions {
+			if item, ok := shard.dataMap[keys[pos]]; ok {
+				delete(shard.dataMap, keys[pos])
+				removed = append(removed, batchItem[K, T]{key: keys[pos], item: item})
+			}
+		}
+		shard.Unlock()
+		for _, r := range removed {
+			c.changeSize(-1)
+			c.changeCost(-r.item.cost)
+			c.removed(r.key, r.item.item, RemovalDeleted)
+		}
+	}
+}
+
+// addFreqBatch send the access of a batch operation to process() as one batch. Small batches are added
+// to the current batch like any other access.
+func (c *CacheData[K, T]) addFreqBatch(accesses []keyAccess[K]) {
+	if c.directAccess || len(accesses) < int(c.batchSize) {
+		for _, access := range accesses {
+			c.addFreq(access.key, access.hash)
+		}
+		return
+	}
+	c.sendAccesses(accesses)
+}
+
+func (c *KeyCache[K, V]) SetMany(items map[K]V, ttl time.Duration) error {
+	now := time.Now()
+	batch := make([]batchItem[K, V], 0, len(items))
+	for key, value := range items {
+		cost := c.itemCost(value)
+		if err := c.checkCost(cost); err != nil {
+			return err
+		}
+		batch = append(batch, batchItem[K, V]{
+			key:  key,
+			hash: c.hash(key),
+			item: c.newItem(value, cost, 0, ttl, now),
+		})
+	}
+	c.data.SetMany(batch)
+	for key := range items {
+		c.loads.forget(key)
+	}
+	return nil
+}
+
+// GetMany return the values of the keys that are present and not expired, missing keys are not in the map.
+// Stale values are returned too and refreshed in background.
+func (c *KeyCache[K, V]) GetMany(keys []K) map[K]V {
+	items, staleKeys := c.data.GetMany(keys, c.hashes(keys))
+	values := make(map[K]V, len(items))
+	now := time.Now()
+	for key, item := range items {
+		values[key] = item.item
+		if staleKeys[key] || c.needRefresh(item, now) {
+			c.refresh(key, item, c.loader)
+		}
+	}
+	return values
+}
+
+func (c *KeyCache[K, V]) DeleteMany(keys []K) {
+	c.data.DelMany(keys, c.hashes(keys))
+	for _, key := range keys {
+		c.loads.forget(key)
+	}
+}
+
+func (c *KeyCache[K, V]) hashes(keys []K) []uint64 {
+	hashes := make([]uint64, len(keys))
+	for pos, key := range keys {
+		hashes[pos] = c.hash(key)
+	}
+	return hashes
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec encode the values (or keys) of the cache to bytes and decode them back, it is used to save the cache
+// to disk.
+type Codec[T any] interface {
+	Encode(value T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// GobCodec encode with encoding/gob. It work for most types without any code, but unexported struct
+// fields are not saved and interface values need gob.Register.
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Encode(value T) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(&value); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (GobCodec[T]) Decode(data []byte) (T, error) {
+	var value T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return value, err
+}
+
+/** codec whose output never has a new line, BigCache save it in the file without base64 */
+type lineCodec interface {
+	lineSafe()
+}
+
+// StringCodec save the string as it is. The string must not have a new line, it is the codec of the
+// string BigCache made by NewBigCacheRing.
+type StringCodec struct{}
+
+func (StringCodec) Encode(value string) ([]byte, error) {
+	return []byte(value), nil
+}
+
+func (StringCodec) Decode(data []byte) (string, error) {
+	return string(data), nil
+}
+
+func (StringCodec) lineSafe() {}
+
+// JSONCodec encode with encoding/json, only the exported fields of a struct are saved.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(value T) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var value T
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// BinaryCodec use the MarshalBinary of the value and the UnmarshalBinary of its pointer, like
+// BinaryCodec[time.Time, *time.Time]{}.
+type BinaryCodec[T encoding.BinaryMarshaler, PT interface {
+	*T
+	encoding.BinaryUnmarshaler
+}] struct{}
+
+func (BinaryCodec[T, PT]) Encode(value T) ([]byte, error) {
+	return value.MarshalBinary()
+}
+
+func (BinaryCodec[T, PT]) Decode(data []byte) (T, error) {
+	var value T
+	err := PT(&value).UnmarshalBinary(data)
+	return value, err
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+/** The memory cache never block for long, so the context is only checked before the operation. The loader
+and the file read of bigCacheRing can block, they stop when the context is done. In every case the
+error returned for a done context is its cause. */
+
+func (c *KeyCache[K, V]) GetCtx(ctx context.Context, key K) (V, error) {
+	if ctx.Err() != nil {
+		var value V
+		return value, context.Cause(ctx)
+	}
+	return c.Get(key)
+}
+
+func (c *KeyCache[K, V]) SetCtx(ctx context.Context, key K, value V, ttl time.Duration) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return c.Set(key, value, ttl)
+}
+
+// GetOrLoadCtx is GetOrLoad with a loader that get a context. The caller stop waiting when ctx is done, the
+// loader context is cancelled only when all the callers waiting for the same key are gone.
+func (c *KeyCache[K, V]) GetOrLoadCtx(ctx context.Context, key K, ttl time.Duration, loader func(ctx context.Context, key K) (V, error)) (V, error) {
+	var refreshLoader func(K) (V, error)
+	if loader != nil {
+		refreshLoader = func(key K) (V, error) {
+			return loader(context.WithoutCancel(ctx), key)
+		}
+	}
+	value, err := c.get(key, refreshLoader)
+	if err == nil {
+		return value, nil
+	}
+	if loader == nil {
+		return value, errors.New("loader is nil")
+	}
+	return c.loads.doCtx(ctx, key, func(loadCtx context.Context) (V, error) {
+		value, err := loader(loadCtx, key)
+		if err != nil {
+			return value, err
+		}
+		c.Set(key, value, ttl)
+		return value, nil
+	})
+}
+
+func (c *BigCache[T]) GetCtx(ctx context.Context, key string) (T, error) {
+	return c.get(ctx, key)
+}
+
+func (c *BigCache[T]) SetCtx(ctx context.Context, key string, value T) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return c.Set(key, value)
+}
@@ -2,96 +2,174 @@ package cache
 
 import (
 	"errors"
+	"math/bits"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	cacheheap "github.com/amitiwary999/go-cache/internal/heap"
 )
 
 type cacheItem[T any] struct {
 	item       T
+	cost       int64
 	expiration time.Time
+	/** idle is the sliding expiration. The item expire when it is not read for idle duration, lastAccess is
+	the unix nano time of the last read and is updated atomically by Get under the read lock. */
+	idle       time.Duration
+	lastAccess int64
+	/** ttl the item was set with, a refresh set the new value with the same ttl */
+	ttl     time.Duration
+	written time.Time
+}
+
+func (i *cacheItem[T]) expired(now time.Time) bool {
+	if !i.expiration.IsZero() && now.After(i.expiration) {
+		return true
+	}
+	return i.idle > 0 && now.UnixNano() > atomic.LoadInt64(&i.lastAccess)+int64(i.idle)
 }
 
-type cacheDataMap[T any] struct {
+// deadline return the earliest time the item can expire if it is not read again, zero if it never expire.
+func (i *cacheItem[T]) deadline() time.Time {
+	if i.idle <= 0 {
+		return i.expiration
+	}
+	idleDeadline := time.Unix(0, atomic.LoadInt64(&i.lastAccess)+int64(i.idle))
+	if i.expiration.IsZero() || idleDeadline.Before(i.expiration) {
+		return idleDeadline
+	}
+	return i.expiration
+}
+
+func (i *cacheItem[T]) touch(now time.Time) {
+	if i.idle > 0 {
+		atomic.StoreInt64(&i.lastAccess, now.UnixNano())
+	}
+}
+
+type cacheDataMap[K comparable, T any] struct {
 	sync.RWMutex
-	dataMap map[uint64]cacheItem[T]
+	dataMap map[K]*cacheItem[T]
+	stats   shardStats
+}
+
+type keyAccess[K comparable] struct {
+	key  K
+	hash uint64
 }
 
-type CacheData[T any] struct {
+type CacheData[K comparable, T any] struct {
 	sync.Mutex
-	data           []*cacheDataMap[T]
-	getCountBatch  []uint64
+	data           []*cacheDataMap[K, T]
+	shardShift     uint
+	accesses       *accessBuffer[K]
 	capacity       uint64
 	size           int64
-	itemsCh        chan []uint64
+	maxCost        int64
+	cost           int64
+	itemsCh        chan []keyAccess[K]
 	done           chan int
 	batchSize      uint64
-	lfuSketch      countmin
-	lfuQueue       PriorityQueue
-	queuePosMap    map[uint64]int
-	expirationData *expirationData[T]
-	accessCount    uint64
+	policy         EvictionPolicy[K]
+	directAccess   bool
+	hash           func(K) uint64
+	expirationData *expirationData[K]
+	notifier       *removalNotifier[K, T]
+	stats          cacheStats
+	staleWindow    time.Duration
 }
 
-type cacheOp[T any] interface {
-	Set(uint64, T, time.Time)
-	Get(uint64) (T, error)
-	Del(uint64)
+type cacheOp[K comparable, T any] interface {
+	Set(K, uint64, *cacheItem[T])
+	Replace(K, uint64, *cacheItem[T], *cacheItem[T]) bool
+	Get(K, uint64) (*cacheItem[T], bool, error)
+	Del(K, uint64)
 	Reset()
 	RemoveExpiredItem()
+	Stats() Stats
+	Range(func(K, T) bool)
+	RangeItems(func(K, *cacheItem[T]) bool)
+	Frequency(K, uint64) (uint64, bool)
+	SetFrequency(K, uint64, uint64)
+	Len() int
+	SetMany([]batchItem[K, T])
+	GetMany([]K, []uint64) (map[K]*cacheItem[T], map[K]bool)
+	DelMany([]K, []uint64)
 }
 
-func newCacheDataMap[T any]() *cacheDataMap[T] {
-	c := &cacheDataMap[T]{
-		dataMap: make(map[uint64]cacheItem[T]),
+const defaultShards = 256
+
+// shardIndex pick the shard from the high bits of the hash. The keys of one shard still use all the
+// counters of the sketch, because every row xor the hash with its seed and multiply it before it take the
+// high bits. The doorkeeper take its first bit from the high bits too, but its other bits add a step from
+// the low bits. The expiration wheel does not use the hash.
+func (c *CacheData[K, T]) shardIndex(hash uint64) uint64 {
+	return hash >> c.shardShift
+}
+
+func newCacheDataMap[K comparable, T any]() *cacheDataMap[K, T] {
+	c := &cacheDataMap[K, T]{
+		dataMap: make(map[K]*cacheItem[T]),
 	}
 	return c
 }
 
-func NewCacheData[T any](cConfig *CacheConfig, done chan int) cacheOp[T] {
-	c := &CacheData[T]{
-		data:           make([]*cacheDataMap[T], 256),
-		getCountBatch:  make([]uint64, cConfig.CountBatch),
+func NewCacheData[K comparable, T any](cConfig *CacheConfig[K, T], done chan int) cacheOp[K, T] {
+	c := &CacheData[K, T]{
 		capacity:       cConfig.Capacity,
-		itemsCh:        make(chan []uint64, 5),
+		maxCost:        cConfig.MaxCost,
+		staleWindow:    cConfig.StaleWindow,
+		itemsCh:        make(chan []keyAccess[K], 5),
 		batchSize:      cConfig.CountBatch,
 		done:           done,
-		lfuSketch:      *newCountMin(cConfig.FreqCount),
-		lfuQueue:       make(PriorityQueue, 0),
-		queuePosMap:    make(map[uint64]int),
-		expirationData: newExpirationData[T](),
+		policy:         cConfig.Policy,
+		hash:           cConfig.KeyHash,
+		expirationData: newExpirationData[K](cConfig.ExpirationResolution, cConfig.MaxExpirePerTick),
+		notifier:       newRemovalNotifier(cConfig.OnEvict, done),
+	}
+	if c.policy == nil {
+		c.policy = NewLFUPolicy[K](cConfig.Capacity, cConfig.MaxCost, cConfig.SketchError, cConfig.SketchConfidence)
+	}
+	if c.hash == nil {
+		c.hash = keyHash[K]
+	}
+	_, c.directAccess = c.policy.(concurrentPolicy)
+	c.accesses = newAccessBuffer[K](cConfig.CountBatch, c.sendAccesses)
+	shards := defaultShards
+	if cConfig.Shards > 0 {
+		shards = cConfig.Shards
 	}
+	shardBits := bits.Len(uint(shards - 1))
+	c.data = make([]*cacheDataMap[K, T], 1<<shardBits)
+	c.shardShift = uint(64 - shardBits)
 	for i := range c.data {
-		c.data[i] = newCacheDataMap[T]()
+		c.data[i] = newCacheDataMap[K, T]()
+	}
+	if !c.directAccess {
+		go c.process()
 	}
-	cacheheap.Init(&c.lfuQueue)
-	go c.process()
 	return c
 }
 
-func (c *CacheData[T]) close() {
-	close(c.itemsCh)
-}
-
-func (c *CacheData[T]) Set(key uint64, value T, expiration time.Time) {
-	i := key % 256
-	item := cacheItem[T]{
-		item:       value,
-		expiration: expiration,
-	}
+func (c *CacheData[K, T]) Set(key K, hash uint64, item *cacheItem[T]) {
+	i := c.shardIndex(hash)
 	oldItem, update := c.data[i].set(key, item)
 	if update {
-		c.expirationData.update(key, oldItem.expiration, expiration)
+		c.expirationData.add(key, hash, c.removeAt(item))
+		c.changeCost(item.cost - oldItem.cost)
+		c.removed(key, oldItem.item, RemovalReplaced)
 	} else {
-		c.expirationData.add(key, expiration)
-		c.addFreq(key)
+		c.expirationData.add(key, hash, c.removeAt(item))
+		c.addFreq(key, hash)
 		c.changeSize(1)
-		c.removeExcessItem()
+		c.changeCost(item.cost)
+		c.Lock()
+		c.policy.OnInsert(key, hash, item.cost)
+		c.Unlock()
 	}
+	c.removeExcessItem()
 }
 
-func (c *cacheDataMap[T]) set(key uint64, item cacheItem[T]) (cacheItem[T], bool) {
+func (c *cacheDataMap[K, T]) set(key K, item *cacheItem[T]) (*cacheItem[T], bool) {
 	c.Lock()
 	defer c.Unlock()
 	oldItem, ok := c.dataMap[key]
@@ -99,106 +177,252 @@ func (c *cacheDataMap[T]) set(key uint64, item cacheItem[T]) (cacheItem[T], bool
 	return oldItem, ok
 }
 
-func (c *CacheData[T]) Get(key uint64) (T, error) {
-	i := key % 256
-	c.addFreq(key)
-	return c.data[i].get(key)
+// Replace set item only if old is still the item of the key. It return false when the key is deleted or
+// set again since old was read, and the cache is not changed.
+func (c *CacheData[K, T]) Replace(key K, hash uint64, old *cacheItem[T], item *cacheItem[T]) bool {
+	i := c.shardIndex(hash)
+	if !c.data[i].replace(key, old, item) {
+		return false
+	}
+	c.expirationData.add(key, hash, c.removeAt(item))
+	c.changeCost(item.cost - old.cost)
+	c.removed(key, old.item, RemovalReplaced)
+	c.removeExcessItem()
+	return true
 }
 
-func (c *cacheDataMap[T]) get(key uint64) (T, error) {
-	var item T
+func (c *cacheDataMap[K, T]) replace(key K, old *cacheItem[T], item *cacheItem[T]) bool {
+	c.Lock()
+	defer c.Unlock()
+	if c.dataMap[key] != old {
+		return false
+	}
+	c.dataMap[key] = item
+	return true
+}
+
+// removeAt return the time the item should be removed by the expiration cleanup. The expired item is kept
+// for the stale window, so it can be served while it is refreshed.
+func (c *CacheData[K, T]) removeAt(item *cacheItem[T]) time.Time {
+	deadline := item.deadline()
+	if deadline.IsZero() {
+		return deadline
+	}
+	return deadline.Add(c.staleWindow)
+}
+
+// Get return the item and true if the item has expired but is still in the stale window.
+func (c *CacheData[K, T]) Get(key K, hash uint64) (*cacheItem[T], bool, error) {
+	i := c.shardIndex(hash)
+	c.addFreq(key, hash)
+	return c.data[i].get(key, c.staleWindow)
+}
+
+func (c *cacheDataMap[K, T]) get(key K, staleWindow time.Duration) (*cacheItem[T], bool, error) {
 	c.RLock()
 	defer c.RUnlock()
 	cacheItem, ok := c.dataMap[key]
 	if !ok {
-		return item, errors.New("item not found")
+		atomic.AddUint64(&c.stats.misses, 1)
+		return nil, false, errors.New("item not found")
+	}
+	now := time.Now()
+	stale := cacheItem.expired(now)
+	if stale && (staleWindow <= 0 || cacheItem.expired(now.Add(-staleWindow))) {
+		atomic.AddUint64(&c.stats.misses, 1)
+		atomic.AddUint64(&c.stats.expiredOnRead, 1)
+		return nil, false, errors.New("item has expired")
 	}
-	if !cacheItem.expiration.IsZero() && time.Now().After(cacheItem.expiration) {
-		return item, errors.New("item has expired")
+	if stale {
+		atomic.AddUint64(&c.stats.staleHits, 1)
+	} else {
+		cacheItem.touch(now)
 	}
-	return cacheItem.item, nil
+	atomic.AddUint64(&c.stats.hits, 1)
+	return cacheItem, stale, nil
 }
 
-func (c *CacheData[T]) Del(key uint64) {
-	i := key % 256
-	ok := c.data[i].del(key)
+func (c *CacheData[K, T]) Del(key K, hash uint64) {
+	c.Lock()
+	c.removeKey(key)
+	c.Unlock()
+	c.delData(key, hash, RemovalDeleted)
+}
+
+// removeKey remove the key from the eviction policy. Must be called with the lock held.
+func (c *CacheData[K, T]) removeKey(key K) {
+	c.policy.OnRemove(key)
+}
+
+func (c *CacheData[K, T]) delData(key K, hash uint64, reason RemovalReason) {
+	i := c.shardIndex(hash)
+	item, ok := c.data[i].del(key)
 	if ok {
 		c.changeSize(-1)
+		c.changeCost(-item.cost)
+		c.removed(key, item.item, reason)
 	}
 }
 
-func (c *cacheDataMap[T]) del(key uint64) bool {
-	_, ok := c.dataMap[key]
+func (c *CacheData[K, T]) removed(key K, value T, reason RemovalReason) {
+	c.stats.addRemoval(reason)
+	c.notifier.notify(key, value, reason)
+}
+
+// delExpired remove the key only if it is still expired. The expiration bucket can have a key that was
+// set again with new expiration after it was evicted. A key with sliding expiration that was read after it
+// was put in the bucket is not expired yet, it is moved to the bucket of its new deadline. This way Get
+// only update the access time of the item and never touch the expiration buckets.
+func (c *CacheData[K, T]) delExpired(key K, hash uint64) {
+	i := c.shardIndex(hash)
+	item, ok, deadline := c.data[i].delExpired(key, time.Now().Add(-c.staleWindow))
+	if !ok && !deadline.IsZero() {
+		c.expirationData.add(key, hash, deadline.Add(c.staleWindow))
+	}
+	if ok {
+		c.Lock()
+		c.removeKey(key)
+		c.Unlock()
+		c.changeSize(-1)
+		c.changeCost(-item.cost)
+		c.removed(key, item.item, RemovalExpired)
+	}
+}
+
+func (c *cacheDataMap[K, T]) del(key K) (*cacheItem[T], bool) {
+	c.Lock()
+	defer c.Unlock()
+	item, ok := c.dataMap[key]
+	delete(c.dataMap, key)
+	return item, ok
+}
+
+func (c *cacheDataMap[K, T]) delExpired(key K, now time.Time) (*cacheItem[T], bool, time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	item, ok := c.dataMap[key]
+	if !ok {
+		return nil, false, time.Time{}
+	}
+	if !item.expired(now) {
+		return item, false, item.deadline()
+	}
 	delete(c.dataMap, key)
-	return ok
+	return item, true, time.Time{}
+}
+
+func (c *CacheData[K, T]) RemoveExpiredItem() {
+	c.expirationData.removeExpiredItem(c.delExpired)
+}
+
+func (c *CacheData[K, T]) addFreq(key K, hash uint64) {
+	if c.directAccess {
+		c.policy.OnAccess(key, hash)
+		return
+	}
+	c.accesses.push(key, hash)
 }
 
-func (c *CacheData[T]) RemoveExpiredItem() {
-	c.expirationData.removeExpiredItem(c)
+// sendAccesses give the batch to process() if it is ready for it, otherwise the batch is dropped. Get
+// never wait for process().
+func (c *CacheData[K, T]) sendAccesses(accesses []keyAccess[K]) bool {
+	select {
+	case c.itemsCh <- accesses:
+		return true
+	default:
+		atomic.AddUint64(&c.stats.freqBatchDrops, 1)
+		return false
+	}
 }
 
-func (c *CacheData[T]) addFreq(key uint64) {
-	c.getCountBatch = append(c.getCountBatch, key)
-	if len(c.getCountBatch) >= int(c.batchSize) {
-		c.itemsCh <- c.getCountBatch
-		c.getCountBatch = c.getCountBatch[:0]
+func (c *CacheData[K, T]) overCapacity() bool {
+	if c.capacity > 0 && atomic.LoadInt64(&c.size) > int64(c.capacity) {
+		return true
 	}
-	c.accessCount += 1
+	return c.maxCost > 0 && atomic.LoadInt64(&c.cost) > c.maxCost
 }
 
-func (c *CacheData[T]) removeExcessItem() {
+// removeExcessItem remove the victims of the policy till the cache is under capacity and max cost.
+func (c *CacheData[K, T]) removeExcessItem() {
 	c.Lock()
 	defer c.Unlock()
-	if c.size >= int64(c.capacity) {
-		lfuItemI := cacheheap.Pop(&c.lfuQueue)
-		if lfuItemI != nil {
-			lfuItem := lfuItemI.((*LFUItem))
-			c.Del(lfuItem.key)
+	for c.overCapacity() {
+		key, ok := c.policy.Victim()
+		if !ok {
+			return
 		}
+		c.delData(key, c.hash(key), RemovalEvicted)
 	}
 }
 
-func (c *CacheData[T]) process() {
+func (c *CacheData[K, T]) process() {
 	for {
 		select {
 		case items := <-c.itemsCh:
-			uniqueItems := make(map[uint64]struct{})
-			for _, item := range items {
-				c.lfuSketch.setKeyCount(item)
-				uniqueItems[item] = struct{}{}
-			}
-			for k := range uniqueItems {
-				freq := c.lfuSketch.getKeyCount(k)
-				item := &LFUItem{
-					key:  k,
-					freq: freq,
-				}
-				oldPos, pOk := c.queuePosMap[k]
-				if pOk {
-					c.lfuQueue.update(item, oldPos)
-					cacheheap.Fix(&c.lfuQueue, oldPos)
-				} else {
-					pos := cacheheap.Push(&c.lfuQueue, item)
-					c.queuePosMap[k] = pos
-				}
-			}
-			if c.accessCount > 5*c.capacity {
-				c.Reset()
-				c.accessCount = 0
-			}
-			uniqueItems = nil
+			c.applyAccesses(items)
 		case <-c.done:
-			c.close()
+			/** itemsCh is not closed, a Get that race with the close only drop its batch */
+			return
+		}
+	}
+}
+
+func (c *CacheData[K, T]) applyAccesses(items []keyAccess[K]) {
+	c.Lock()
+	defer c.Unlock()
+	for _, item := range items {
+		c.policy.OnAccess(item.key, item.hash)
+	}
+}
+
+// drainAccesses give the policy the reads waiting in the access buffer and in itemsCh at once, so the
+// frequencies are up to date without waiting for process().
+func (c *CacheData[K, T]) drainAccesses() {
+	for {
+		select {
+		case items := <-c.itemsCh:
+			c.applyAccesses(items)
+		default:
+			c.accesses.drain(c.applyAccesses)
 			return
 		}
 	}
 }
 
-func (c *CacheData[T]) changeSize(changeSize int64) {
-	c.size = c.size + changeSize
+func (c *CacheData[K, T]) changeSize(changeSize int64) {
+	atomic.AddInt64(&c.size, changeSize)
+}
+
+func (c *CacheData[K, T]) changeCost(changeCost int64) {
+	atomic.AddInt64(&c.cost, changeCost)
+}
+
+// Frequency return the frequency of the key, false if the policy does not count frequencies.
+func (c *CacheData[K, T]) Frequency(key K, hash uint64) (uint64, bool) {
+	p, ok := c.policy.(frequencyPolicy[K])
+	if !ok {
+		return 0, false
+	}
+	c.Lock()
+	defer c.Unlock()
+	return p.frequency(hash), true
+}
+
+func (c *CacheData[K, T]) SetFrequency(key K, hash uint64, freq uint64) {
+	p, ok := c.policy.(frequencyPolicy[K])
+	if !ok {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	p.setFrequency(key, hash, freq)
 }
 
-func (c *CacheData[T]) Reset() {
-	c.lfuQueue.reset()
-	c.lfuSketch.reset()
+// Reset reset the frequencies of the policy, a policy without frequencies has nothing to reset.
+func (c *CacheData[K, T]) Reset() {
+	c.Lock()
+	defer c.Unlock()
+	if p, ok := c.policy.(resettablePolicy); ok {
+		p.Reset()
+	}
 }
@@ -8,69 +8,202 @@ import (
 /** I loved this idea of bucket the item according to expiration. I got it while reading the document of Ristretto,
 a very good library for local cache */
 
-type itemExpireData map[uint64]byte
+// The buckets are kept in a hierarchical timing wheel. Every level has wheelSlots slots, a slot of level 0 is
+// one tick of the resolution and a slot of level n is wheelSlots times a slot of level n-1. A key is put in
+// the lowest level that reach its expiration, and when a slot of a higher level comes the keys are moved to
+// the lower levels. Keys beyond the last level wait in overflow. This way the cleanup only look at the slots
+// of the ticks that passed, however late it run, and a slot is dropped as soon as it is empty.
+const (
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits
+	wheelMask   = wheelSlots - 1
+	wheelLevels = 4
+	/** default of MaxExpirePerTick */
+	defaultMaxExpirePerTick = 10000
+)
 
-type expirationData[T any] struct {
-	sync.Mutex
-	expirationBucket map[int64]itemExpireData
+type wheelEntry struct {
+	hash uint64
+	tick int64
 }
 
-func expirationBucketKey(t time.Time) int64 {
-	return (t.Unix() / ExpirationInterval) + 1
+type itemExpireData[K comparable] map[K]wheelEntry
+
+/** level -1 is the overflow */
+type wheelPos struct {
+	level int
+	slot  int
 }
 
-func newExpirationData[T any]() *expirationData[T] {
-	return &expirationData[T]{
-		expirationBucket: make(map[int64]itemExpireData),
-	}
+type expirationData[K comparable] struct {
+	sync.Mutex
+	resolution int64
+	/** last tick that is fully processed */
+	tick      int64
+	levels    [wheelLevels][wheelSlots]itemExpireData[K]
+	overflow  itemExpireData[K]
+	positions map[K]wheelPos
+	maxExpire int
 }
 
-func (e *expirationData[T]) add(key uint64, expiration time.Time) {
-	if expiration.IsZero() {
-		return
+func newExpirationData[K comparable](resolution time.Duration, maxExpire int) *expirationData[K] {
+	if resolution <= 0 {
+		resolution = defaultExpirationResolution
+	}
+	if maxExpire <= 0 {
+		maxExpire = defaultMaxExpirePerTick
+	}
+	e := &expirationData[K]{
+		resolution: int64(resolution),
+		positions:  make(map[K]wheelPos),
+		maxExpire:  maxExpire,
 	}
+	e.tick = e.tickOf(time.Now())
+	return e
+}
+
+func (e *expirationData[K]) tickOf(t time.Time) int64 {
+	return t.UnixNano() / e.resolution
+}
 
+// add put the key in the slot of its expiration, the key is first removed from its old slot. Zero
+// expiration only remove the key, the item never expire.
+func (e *expirationData[K]) add(key K, hash uint64, expiration time.Time) {
 	if e == nil {
 		return
 	}
-
-	bucketNum := expirationBucketKey(expiration)
 	e.Mutex.Lock()
 	defer e.Mutex.Unlock()
-	bucket, ok := e.expirationBucket[bucketNum]
+	e.remove(key)
+	if expiration.IsZero() {
+		return
+	}
+	/** the key is processed at the first tick after its expiration */
+	e.place(key, wheelEntry{hash: hash, tick: e.tickOf(expiration) + 1}, e.tick+1)
+}
+
+func (e *expirationData[K]) remove(key K) {
+	pos, ok := e.positions[key]
 	if !ok {
-		bucket = make(itemExpireData)
-		e.expirationBucket[bucketNum] = bucket
+		return
+	}
+	delete(e.positions, key)
+	if pos.level < 0 {
+		delete(e.overflow, key)
+		if len(e.overflow) == 0 {
+			e.overflow = nil
+		}
+		return
+	}
+	slot := e.levels[pos.level][pos.slot]
+	delete(slot, key)
+	if len(slot) == 0 {
+		e.levels[pos.level][pos.slot] = nil
 	}
-	bucket[key] = byte(1)
 }
 
-func (e *expirationData[T]) update(key uint64, prevExpiration time.Time, updateExpiration time.Time) {
-	e.Mutex.Lock()
-	defer e.Mutex.Unlock()
-	oldBucketNum := expirationBucketKey(prevExpiration)
-	oldBucket, ok := e.expirationBucket[oldBucketNum]
-	if ok {
-		delete(oldBucket, key)
+// place put the key in the wheel relative to base, the first tick that is not processed yet. A key is in
+// level n when its tick is less than wheelSlots^(n+1) ticks after base, so its slot comes before the slot
+// of base comes again.
+func (e *expirationData[K]) place(key K, entry wheelEntry, base int64) {
+	/** a key that is already due goes to the base tick */
+	tick := max(entry.tick, base)
+	delta := tick - base
+	pos := wheelPos{level: -1}
+	for level := 0; level < wheelLevels; level++ {
+		if delta < int64(1)<<(wheelBits*(level+1)) {
+			pos = wheelPos{level: level, slot: int((tick >> (wheelBits * level)) & wheelMask)}
+			break
+		}
+	}
+	var slot itemExpireData[K]
+	if pos.level < 0 {
+		if e.overflow == nil {
+			e.overflow = make(itemExpireData[K])
+		}
+		slot = e.overflow
+	} else {
+		slot = e.levels[pos.level][pos.slot]
+		if slot == nil {
+			slot = make(itemExpireData[K])
+			e.levels[pos.level][pos.slot] = slot
+		}
 	}
+	slot[key] = entry
+	e.positions[key] = pos
+}
 
-	newBucketNum := expirationBucketKey(updateExpiration)
-	newBucket, ok := e.expirationBucket[newBucketNum]
-	if !ok {
-		newBucket = make(itemExpireData)
-		e.expirationBucket[newBucketNum] = newBucket
+// cascade move the keys of the higher level slots that start at tick to the lower levels. The keys are
+// placed relative to tick, which is processed right after, so a key due at tick goes to the level 0 slot
+// of tick. The highest level is moved first, so its keys can move down again in the same tick.
+func (e *expirationData[K]) cascade(tick int64) {
+	if tick&(int64(1)<<(wheelBits*wheelLevels)-1) == 0 && e.overflow != nil {
+		overflow := e.overflow
+		e.overflow = nil
+		e.replace(overflow, tick)
+	}
+	for level := wheelLevels - 1; level > 0; level-- {
+		if tick&(int64(1)<<(wheelBits*level)-1) != 0 {
+			continue
+		}
+		index := (tick >> (wheelBits * level)) & wheelMask
+		slot := e.levels[level][index]
+		if slot == nil {
+			continue
+		}
+		e.levels[level][index] = nil
+		e.replace(slot, tick)
 	}
-	newBucket[key] = byte(1)
 }
 
-func (e *expirationData[T]) removeExpiredItem(c cacheOp[T]) {
-	removeBucketKey := expirationBucketKey(time.Now()) - 1
+func (e *expirationData[K]) replace(slot itemExpireData[K], base int64) {
+	for key, entry := range slot {
+		delete(e.positions, key)
+		e.place(key, entry, base)
+	}
+}
+
+// removeExpiredItem process every tick from the last processed tick to now, so a late cleanup catch up on
+// the ticks it missed. It call del for at most maxExpire keys, the rest are left in their slot for the next
+// cleanup. The keys are copied before calling del, because del can add the key again to a later slot.
+func (e *expirationData[K]) removeExpiredItem(del func(K, uint64)) {
+	e.expireUntil(e.tickOf(time.Now()), del)
+}
+
+func (e *expirationData[K]) expireUntil(now int64, del func(K, uint64)) {
+	keys := make([]keyAccess[K], 0)
 	e.Mutex.Lock()
-	defer e.Mutex.Unlock()
-	bucket, ok := e.expirationBucket[removeBucketKey]
-	if ok {
-		for key := range bucket {
-			c.Del(key)
+	if len(e.positions) == 0 {
+		e.tick = max(e.tick, now)
+	}
+	for e.tick < now && len(keys) < e.maxExpire {
+		tick := e.tick + 1
+		e.cascade(tick)
+		index := tick & wheelMask
+		slot := e.levels[0][index]
+		for key, entry := range slot {
+			if len(keys) >= e.maxExpire {
+				break
+			}
+			keys = append(keys, keyAccess[K]{key: key, hash: entry.hash})
+			delete(slot, key)
+			delete(e.positions, key)
+		}
+		if len(slot) > 0 {
+			break
 		}
+		e.levels[0][index] = nil
+		e.tick = tick
 	}
+	e.Mutex.Unlock()
+	for _, k := range keys {
+		del(k.key, k.hash)
+	}
+}
+
+// count return the number of keys waiting in the wheel.
+func (e *expirationData[K]) count() int {
+	e.Mutex.Lock()
+	defer e.Mutex.Unlock()
+	return len(e.positions)
 }
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"iter"
+	"time"
+)
+
+type keyValue[K comparable, T any] struct {
+	key   K
+	value T
+	item  *cacheItem[T]
+}
+
+// liveItems copy the live items of the shard under the read lock, so the lock is not held while the
+// caller handles the items.
+func (c *cacheDataMap[K, T]) liveItems(now time.Time) []keyValue[K, T] {
+	c.RLock()
+	defer c.RUnlock()
+	items := make([]keyValue[K, T], 0, len(c.dataMap))
+	for key, item := range c.dataMap {
+		if item.expired(now) {
+			continue
+		}
+		items = append(items, keyValue[K, T]{key: key, value: item.item, item: item})
+	}
+	return items
+}
+
+func (c *cacheDataMap[K, T]) liveCount(now time.Time) int {
+	c.RLock()
+	defer c.RUnlock()
+	count := 0
+	for _, item := range c.dataMap {
+		if !item.expired(now) {
+			count++
+		}
+	}
+	return count
+}
+
+func (c *CacheData[K, T]) Range(fn func(K, T) bool) {
+	for _, shard := range c.data {
+		for _, kv := range shard.liveItems(time.Now()) {
+			if !fn(kv.key, kv.value) {
+				return
+			}
+		}
+	}
+}
+
+// RangeItems is Range that give the whole item, with its expiration and cost.
+func (c *CacheData[K, T]) RangeItems(fn func(K, *cacheItem[T]) bool) {
+	for _, shard := range c.data {
+		for _, kv := range shard.liveItems(time.Now()) {
+			if !fn(kv.key, kv.item) {
+				return
+			}
+		}
+	}
+}
+
+func (c *CacheData[K, T]) Len() int {
+	count := 0
+	now := time.Now()
+	for _, shard := range c.data {
+		count += shard.liveCount(now)
+	}
+	return count
+}
+
+// All iterate the items that have not expired. It is not a snapshot of the whole cache, every shard is
+// copied when the iteration reach it, so items set or deleted during the iteration may or may not be seen.
+// Reading the items does not count as access for eviction.
+func (c *KeyCache[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		c.data.Range(yield)
+	}
+}
+
+func (c *KeyCache[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		c.data.Range(func(key K, _ V) bool {
+			return yield(key)
+		})
+	}
+}
+
+// Len return the number of items that have not expired.
+func (c *KeyCache[K, V]) Len() int {
+	return c.data.Len()
+}
+
+func (c *cacheRing[T]) All() iter.Seq2[string, T] {
+	return func(yield func(string, T) bool) {
+		for _, r := range c.data {
+			item, ok := r.Value.(*cacheRingItem[T])
+			if !ok || item == nil {
+				continue
+			}
+			if !yield(item.keyString, item.item) {
+				return
+			}
+		}
+	}
+}
+
+func (c *cacheRing[T]) Keys() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for key := range c.All() {
+			if !yield(key) {
+				return
+			}
+		}
+	}
+}
+
+func (c *cacheRing[T]) Len() int {
+	return len(c.data)
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+func uintHash(v uint64) uint64 {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return xxhash.Sum64(b[:])
+}
+
+func floatHash(v float64) uint64 {
+	/** 0.0 and -0.0 are the same key, so they must hash the same */
+	if v == 0 {
+		return uintHash(0)
+	}
+	return uintHash(math.Float64bits(v))
+}
+
+// keyHash hash the key of cache. Common key types are hashed directly, any other comparable type
+// (like struct or array) is hashed field by field, so keys that are == always hash the same. Hash
+// collision is fine because the key itself is compared on lookup.
+func keyHash[K comparable](key K) uint64 {
+	switch k := any(key).(type) {
+	case string:
+		return xxhash.Sum64String(k)
+	case int:
+		return uintHash(uint64(k))
+	case int8:
+		return uintHash(uint64(k))
+	case int16:
+		return uintHash(uint64(k))
+	case int32:
+		return uintHash(uint64(k))
+	case int64:
+		return uintHash(uint64(k))
+	case uint:
+		return uintHash(uint64(k))
+	case uint8:
+		return uintHash(uint64(k))
+	case uint16:
+		return uintHash(uint64(k))
+	case uint32:
+		return uintHash(uint64(k))
+	case uint64:
+		return uintHash(k)
+	case uintptr:
+		return uintHash(uint64(k))
+	case float32:
+		return floatHash(float64(k))
+	case float64:
+		return floatHash(k)
+	case bool:
+		if k {
+			return uintHash(1)
+		}
+		return uintHash(0)
+	default:
+		d := xxhash.New()
+		hashValue(d, reflect.ValueOf(key))
+		return d.Sum64()
+	}
+}
+
+// hashValue write the value to the digest the way == compare it. -0.0 is written as 0.0, pointers and
+// channels by their address, an interface by its dynamic value and blank fields are skipped.
+func hashValue(d *xxhash.Digest, v reflect.Value) {
+	var b [8]byte
+	write := func(u uint64) {
+		binary.LittleEndian.PutUint64(b[:], u)
+		d.Write(b[:])
+	}
+	floatBits := func(f float64) uint64 {
+		if f == 0 {
+			return 0
+		}
+		return math.Float64bits(f)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			write(1)
+		} else {
+			write(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		write(uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		write(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		write(floatBits(v.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		write(floatBits(real(v.Complex())))
+		write(floatBits(imag(v.Complex())))
+	case reflect.String:
+		write(uint64(v.Len()))
+		d.WriteString(v.String())
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		write(uint64(v.Pointer()))
+	case reflect.Interface:
+		if v.IsNil() {
+			write(0)
+			return
+		}
+		hashValue(d, v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			hashValue(d, v.Index(i))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).Name != "_" {
+				hashValue(d, v.Field(i))
+			}
+		}
+	}
+}
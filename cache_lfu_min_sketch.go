@@ -2,58 +2,106 @@ package cache
 
 import (
 	"math"
+	"math/bits"
 	"math/rand"
 	"time"
 )
 
-const seedLen = 3
+/** Count-min sketch with 4 bit counters, 16 counters are packed in every uint64. A counter stop at 15, which is
+enough to tell hot keys from cold ones, and halve() age all the counters so old frequencies fade out instead of
+being wiped at once. Increment is the conservative update, only the counters that hold the minimum are
+incremented, so a key does not grow the counters it share with hotter keys. */
 
-type countmin struct {
-	filterItemSize uint64
-	filter         [][]uint64
-	seed           []uint64
-}
+const (
+	counterBits   = 4
+	counterMax    = 1<<counterBits - 1
+	countersInU64 = 64 / counterBits
+	/** every counter halved, the high bit of each counter is cleared after the shift */
+	halveMask = 0x7777777777777777
+	/** limits of the width, so a silly error rate can not make the sketch useless or huge */
+	minSketchWidth = 16
+	maxSketchWidth = 1 << 30
+	maxSketchDepth = 16
+)
 
-func minFunc(a uint64, b uint64) uint64 {
-	if a > b {
-		return b
-	}
-	return a
+type countmin struct {
+	rows      [][]uint64
+	seed      []uint64
+	widthBits uint
 }
 
-func newCountMin(size uint64) *countmin {
+// newCountMin size the sketch for the error rate and confidence. The estimate of a key is more than its
+// count by at most errorRate times the counted accesses with probability confidence, so the width is
+// e/errorRate and the depth is ln(1/(1-confidence)).
+func newCountMin(errorRate float64, confidence float64) *countmin {
+	width := uint64(math.Ceil(math.E / errorRate))
+	width = min(max(width, minSketchWidth), maxSketchWidth)
+	widthBits := uint(bits.Len64(width - 1))
+	depth := int(math.Ceil(math.Log(1 / (1 - confidence))))
+	depth = min(max(depth, 1), maxSketchDepth)
 	cm := &countmin{
-		filterItemSize: size,
-		filter:         make([][]uint64, seedLen),
-		seed:           make([]uint64, seedLen),
+		rows:      make([][]uint64, depth),
+		seed:      make([]uint64, depth),
+		widthBits: widthBits,
 	}
-	for i := 0; i < seedLen; i++ {
-		cm.filter[i] = make([]uint64, size)
-		cm.seed[i] = rand.New(rand.NewSource(time.Now().UnixNano())).Uint64()
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := range cm.rows {
+		cm.rows[i] = make([]uint64, (1<<widthBits)/countersInU64)
+		cm.seed[i] = r.Uint64()
 	}
 	return cm
 }
 
+func (c *countmin) width() int {
+	return 1 << c.widthBits
+}
+
+func (c *countmin) depth() int {
+	return len(c.rows)
+}
+
+/** position of the counter of the hash in the row of the seed, the multiply spread the hash over all the bits */
+func (c *countmin) position(hash uint64, seed uint64) (int, uint) {
+	pos := ((hash ^ seed) * 0x9E3779B97F4A7C15) >> (64 - c.widthBits)
+	return int(pos / countersInU64), uint(pos%countersInU64) * counterBits
+}
+
+func (c *countmin) counter(row int, hash uint64) uint64 {
+	word, shift := c.position(hash, c.seed[row])
+	return (c.rows[row][word] >> shift) & counterMax
+}
+
 func (c *countmin) setKeyCount(hash uint64) {
+	count := c.getKeyCount(hash)
+	if count >= counterMax {
+		return
+	}
 	for i, s := range c.seed {
-		index := (hash ^ s) % uint64(c.filterItemSize)
-		c.filter[i][index] = c.filter[i][index] + 1
+		word, shift := c.position(hash, s)
+		if (c.rows[i][word]>>shift)&counterMax == count {
+			c.rows[i][word] += 1 << shift
+		}
 	}
 }
 
 func (c *countmin) getKeyCount(hash uint64) uint64 {
-	var min uint64 = math.MaxUint64
-	for i, s := range c.seed {
-		index := (hash ^ s) % uint64(c.filterItemSize)
-		min = minFunc(min, c.filter[i][index])
+	var count uint64 = counterMax
+	for i := range c.rows {
+		count = min(count, c.counter(i, hash))
 	}
-	return min
+	return count
 }
 
-func (c *countmin) reset() {
-	for i := 0; i < len(c.seed); i++ {
-		for j := 0; j < int(c.filterItemSize); j++ {
-			c.filter[i][j] = 0
+func (c *countmin) halve() {
+	for _, row := range c.rows {
+		for j := range row {
+			row[j] = (row[j] >> 1) & halveMask
 		}
 	}
 }
+
+func (c *countmin) reset() {
+	for _, row := range c.rows {
+		clear(row)
+	}
+}
@@ -1,42 +1,57 @@
 package cache
 
-type LFUItem struct {
-	key  uint64
+type LFUItem[K comparable] struct {
+	key  K
+	hash uint64
 	freq uint64
+	/** position of the item in the heap, kept up to date on every swap so the item can be fixed in place */
+	index int
 }
 
-type PriorityQueue []*LFUItem
+type PriorityQueue[K comparable] []*LFUItem[K]
 
-func (pq PriorityQueue) Len() int { return len(pq) }
+func (pq PriorityQueue[K]) Len() int { return len(pq) }
 
-func (pq PriorityQueue) Less(i, j int) bool {
+func (pq PriorityQueue[K]) Less(i, j int) bool {
 	return pq[i].freq < pq[j].freq
 }
 
-func (pq PriorityQueue) Swap(i, j int) {
+func (pq PriorityQueue[K]) Swap(i, j int) {
 	pq[i], pq[j] = pq[j], pq[i]
+	pq[i].index = i
+	pq[j].index = j
 }
 
-func (pq *PriorityQueue) Push(item any) {
-	*pq = append(*pq, item.(*LFUItem))
+func (pq *PriorityQueue[K]) Push(item any) {
+	lfuItem := item.(*LFUItem[K])
+	lfuItem.index = len(*pq)
+	*pq = append(*pq, lfuItem)
 }
 
-func (pq *PriorityQueue) Pop() any {
+func (pq *PriorityQueue[K]) Pop() any {
 	prev := *pq
 	if len(prev) == 0 {
 		return nil
 	}
 	item := prev[len(prev)-1]
 	prev[len(prev)-1] = nil
+	item.index = -1
 	*pq = prev[0 : len(prev)-1]
 	return item
 }
 
-func (pq PriorityQueue) update(item *LFUItem, pos int) {
-	pq[pos] = item
+func (pq PriorityQueue[K]) update(item *LFUItem[K], freq uint64) {
+	item.freq = freq
 }
 
-func (pq PriorityQueue) reset() {
+// halve the frequencies like the sketch. Halving keep the order of the frequencies, so the heap stay valid.
+func (pq PriorityQueue[K]) halve() {
+	for _, item := range pq {
+		item.freq /= 2
+	}
+}
+
+func (pq PriorityQueue[K]) reset() {
 	for _, item := range pq {
 		item.freq = 1
 	}
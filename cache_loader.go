@@ -0,0 +1,241 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/** Loader calls for the same key are coalesced so that only one of the callers hits the backend
+and all the others wait for its result. This is the same idea as golang.org/x/sync/singleflight */
+
+type loadCall[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+	/** value of the loader panic, every caller waiting for the load panic with it */
+	panicked any
+	/** callers waiting for the load, the load context is cancelled when all of them leave */
+	waiters int
+	cancel  context.CancelCauseFunc
+}
+
+func newLoadCall[V any]() *loadCall[V] {
+	return &loadCall[V]{
+		done: make(chan struct{}),
+	}
+}
+
+type loadError struct {
+	err        error
+	expiration time.Time
+}
+
+type loadGroup[K comparable, V any] struct {
+	sync.Mutex
+	calls    map[K]*loadCall[V]
+	errs     map[K]loadError
+	errorTTL time.Duration
+}
+
+func newLoadGroup[K comparable, V any](errorTTL time.Duration) *loadGroup[K, V] {
+	return &loadGroup[K, V]{
+		calls:    make(map[K]*loadCall[V]),
+		errs:     make(map[K]loadError),
+		errorTTL: errorTTL,
+	}
+}
+
+func (g *loadGroup[K, V]) do(key K, fn func() (V, error)) (V, error) {
+	g.Lock()
+	if le, ok := g.errs[key]; ok {
+		if time.Now().Before(le.expiration) {
+			g.Unlock()
+			var val V
+			return val, le.err
+		}
+		delete(g.errs, key)
+	}
+	if call, ok := g.calls[key]; ok {
+		/** the call may be started by doCtx, this caller can not leave so the load is never cancelled */
+		call.waiters++
+		g.Unlock()
+		<-call.done
+		return call.result()
+	}
+	call := newLoadCall[V]()
+	g.calls[key] = call
+	g.Unlock()
+
+	g.run(key, call, fn)
+	return call.result()
+}
+
+// result return the result of the load, or panic with the value of the loader panic.
+func (call *loadCall[V]) result() (V, error) {
+	if call.panicked != nil {
+		panic(call.panicked)
+	}
+	return call.val, call.err
+}
+
+// doCtx is do that stop waiting when ctx is done and return the cause of it. The load is shared by all the
+// callers, so it run in its own goroutine with a context that is only cancelled when every caller has left.
+func (g *loadGroup[K, V]) doCtx(ctx context.Context, key K, fn func(context.Context) (V, error)) (V, error) {
+	var val V
+	if ctx.Err() != nil {
+		return val, context.Cause(ctx)
+	}
+	g.Lock()
+	if le, ok := g.errs[key]; ok {
+		if time.Now().Before(le.expiration) {
+			g.Unlock()
+			return val, le.err
+		}
+		delete(g.errs, key)
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		loadCtx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+		call = newLoadCall[V]()
+		call.cancel = cancel
+		g.calls[key] = call
+		go g.run(key, call, func() (V, error) {
+			return fn(loadCtx)
+		})
+	}
+	call.waiters++
+	g.Unlock()
+
+	select {
+	case <-call.done:
+		return call.result()
+	case <-ctx.Done():
+		g.Lock()
+		call.waiters--
+		if call.waiters == 0 && call.cancel != nil {
+			call.cancel(context.Cause(ctx))
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+		}
+		g.Unlock()
+		return val, context.Cause(ctx)
+	}
+}
+
+// start run fn in a new goroutine if no load of the key is running and no load error of the key is cached.
+// The caller never wait, it return false when the load is not started.
+func (g *loadGroup[K, V]) start(key K, fn func() (V, error)) bool {
+	g.Lock()
+	if le, ok := g.errs[key]; ok && time.Now().Before(le.expiration) {
+		g.Unlock()
+		return false
+	}
+	if _, ok := g.calls[key]; ok {
+		g.Unlock()
+		return false
+	}
+	call := newLoadCall[V]()
+	g.calls[key] = call
+	g.Unlock()
+
+	/** nobody wait for a background load, so a panic of the loader is only kept as the load error */
+	go g.run(key, call, fn)
+	return true
+}
+
+// run call fn and give its result to the callers waiting for the call. A panic of fn is recovered and kept
+// on the call, the callers panic with it in result, so it never crash a load running in its own goroutine.
+func (g *loadGroup[K, V]) run(key K, call *loadCall[V], fn func() (V, error)) {
+	normalReturn := false
+	defer func() {
+		if !normalReturn {
+			call.panicked = recover()
+			call.err = fmt.Errorf("loader panicked for key %v: %v", key, call.panicked)
+		}
+		g.Lock()
+		/** the call may be already removed because all its waiters left, and a new call of the key started */
+		abandoned := g.calls[key] != call
+		if !abandoned {
+			delete(g.calls, key)
+		}
+		if call.err != nil && g.errorTTL > 0 && !abandoned {
+			g.errs[key] = loadError{
+				err:        call.err,
+				expiration: time.Now().Add(g.errorTTL),
+			}
+		}
+		g.Unlock()
+		if call.cancel != nil {
+			call.cancel(nil)
+		}
+		close(call.done)
+	}()
+	call.val, call.err = fn()
+	normalReturn = true
+}
+
+func (g *loadGroup[K, V]) forget(key K) {
+	g.Lock()
+	defer g.Unlock()
+	delete(g.errs, key)
+}
+
+// GetOrLoad return the stale value in the stale window and refresh it in background with this loader.
+func (c *KeyCache[K, V]) GetOrLoad(key K, ttl time.Duration, loader func(key K) (V, error)) (V, error) {
+	value, err := c.get(key, loader)
+	if err == nil {
+		return value, nil
+	}
+	if loader == nil {
+		return value, errors.New("loader is nil")
+	}
+	return c.loads.do(key, func() (V, error) {
+		value, err := loader(key)
+		if err != nil {
+			return value, err
+		}
+		c.Set(key, value, ttl)
+		return value, nil
+	})
+}
+
+func (c *KeyCache[K, V]) needRefresh(item *cacheItem[V], now time.Time) bool {
+	return c.refreshAfter > 0 && now.Sub(item.written) > c.refreshAfter
+}
+
+// refresh load the key again in background and set it with the ttl of the old item. Only one refresh of a
+// key run at a time. The loaded value replace the item only if the item is still in the cache, a delete or
+// a set of the key during the refresh is kept and the loaded value is dropped.
+func (c *KeyCache[K, V]) refresh(key K, item *cacheItem[V], loader func(key K) (V, error)) {
+	if loader == nil {
+		return
+	}
+	c.loads.start(key, func() (V, error) {
+		normalReturn := false
+		defer func() {
+			if !normalReturn {
+				atomic.AddUint64(&c.stats.refreshFailures, 1)
+			}
+		}()
+		value, err := loader(key)
+		normalReturn = true
+		if err != nil {
+			atomic.AddUint64(&c.stats.refreshFailures, 1)
+			return value, err
+		}
+		cost := c.itemCost(value)
+		if err := c.checkCost(cost); err != nil {
+			atomic.AddUint64(&c.stats.refreshFailures, 1)
+			return value, err
+		}
+		if c.data.Replace(key, c.hash(key), item, c.newItem(value, cost, item.idle, item.ttl, time.Now())) {
+			atomic.AddUint64(&c.stats.refreshes, 1)
+		}
+		return value, nil
+	})
+}
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"container/list"
+)
+
+// EvictionPolicy decide which key leave the cache when it is over capacity or max cost. The cache call the
+// methods with its lock held, one at a time, so a policy does not need its own lock. A policy keeps state
+// for one cache and must not be shared.
+//
+// OnAccess is called for every read, also for the keys that are not in the cache, and for every new key.
+// Reads are counted in batches, so OnAccess can come a bit after the read. OnInsert is called when a new
+// key is added and OnRemove when a key is deleted or expired. Victim remove the key it return from the
+// policy, OnRemove is not called for it. It return false when the policy has no key.
+type EvictionPolicy[K comparable] interface {
+	OnAccess(key K, hash uint64)
+	OnInsert(key K, hash uint64, cost int64)
+	OnRemove(key K)
+	Victim() (K, bool)
+}
+
+/** optional methods of the policy, used by Reset and Stats of the cache */
+type resettablePolicy interface {
+	Reset()
+}
+
+type sketchPolicy interface {
+	sketchResets() uint64
+}
+
+/** policy that can read and set the frequency of a key, used to save the frequencies in the snapshot */
+type frequencyPolicy[K comparable] interface {
+	frequency(hash uint64) uint64
+	setFrequency(key K, hash uint64, freq uint64)
+}
+
+// concurrentPolicy is a policy whose OnAccess is safe to call from many goroutines without the cache lock.
+// The cache call it directly for every read instead of sending the reads in batches to process().
+type concurrentPolicy interface {
+	concurrentAccess()
+}
+
+type lruPolicy[K comparable] struct {
+	items    *list.List
+	itemsMap map[K]*list.Element
+}
+
+// NewLRUPolicy evict the key that was not read for the longest time.
+func NewLRUPolicy[K comparable]() EvictionPolicy[K] {
+	return &lruPolicy[K]{
+		items:    list.New(),
+		itemsMap: make(map[K]*list.Element),
+	}
+}
+
+func (p *lruPolicy[K]) OnAccess(key K, hash uint64) {
+	if e, ok := p.itemsMap[key]; ok {
+		p.items.MoveToFront(e)
+	}
+}
+
+func (p *lruPolicy[K]) OnInsert(key K, hash uint64, cost int64) {
+	if e, ok := p.itemsMap[key]; ok {
+		p.items.MoveToFront(e)
+		return
+	}
+	p.itemsMap[key] = p.items.PushFront(key)
+}
+
+func (p *lruPolicy[K]) OnRemove(key K) {
+	if e, ok := p.itemsMap[key]; ok {
+		p.items.Remove(e)
+		delete(p.itemsMap, key)
+	}
+}
+
+func (p *lruPolicy[K]) Victim() (K, bool) {
+	e := p.items.Back()
+	if e == nil {
+		var key K
+		return key, false
+	}
+	key := p.items.Remove(e).(K)
+	delete(p.itemsMap, key)
+	return key, true
+}
+
+type clockItem[K comparable] struct {
+	key       K
+	reference int8
+}
+
+// CLOCK like cacheRing.findReplaceItem. The keys are in a circle in insert order, a read set the reference
+// and the hand clear the reference of the keys it pass till it find a key with no reference. The circle grow
+// with the cache instead of a fixed ring, because the cache can be bound by cost.
+type clockPolicy[K comparable] struct {
+	items    *list.List
+	itemsMap map[K]*list.Element
+	hand     *list.Element
+}
+
+// NewClockPolicy evict with the CLOCK algorithm of the ring cache.
+func NewClockPolicy[K comparable]() EvictionPolicy[K] {
+	return &clockPolicy[K]{
+		items:    list.New(),
+		itemsMap: make(map[K]*list.Element),
+	}
+}
+
+func (p *clockPolicy[K]) OnAccess(key K, hash uint64) {
+	if e, ok := p.itemsMap[key]; ok {
+		e.Value.(*clockItem[K]).reference = 1
+	}
+}
+
+/** new key is put just behind the hand, so it is the last one the hand reach */
+func (p *clockPolicy[K]) OnInsert(key K, hash uint64, cost int64) {
+	if _, ok := p.itemsMap[key]; ok {
+		return
+	}
+	item := &clockItem[K]{key: key}
+	if p.hand == nil {
+		p.itemsMap[key] = p.items.PushBack(item)
+		p.hand = p.itemsMap[key]
+		return
+	}
+	p.itemsMap[key] = p.items.InsertBefore(item, p.hand)
+}
+
+func (p *clockPolicy[K]) next(e *list.Element) *list.Element {
+	if n := e.Next(); n != nil {
+		return n
+	}
+	return p.items.Front()
+}
+
+func (p *clockPolicy[K]) remove(e *list.Element) {
+	if p.hand == e {
+		p.hand = p.next(e)
+		if p.hand == e {
+			p.hand = nil
+		}
+	}
+	p.items.Remove(e)
+	delete(p.itemsMap, e.Value.(*clockItem[K]).key)
+}
+
+func (p *clockPolicy[K]) OnRemove(key K) {
+	if e, ok := p.itemsMap[key]; ok {
+		p.remove(e)
+	}
+}
+
+func (p *clockPolicy[K]) Victim() (K, bool) {
+	if p.hand == nil {
+		var key K
+		return key, false
+	}
+	for p.hand.Value.(*clockItem[K]).reference == 1 {
+		p.hand.Value.(*clockItem[K]).reference = 0
+		p.hand = p.next(p.hand)
+	}
+	key := p.hand.Value.(*clockItem[K]).key
+	p.remove(p.hand)
+	return key, true
+}
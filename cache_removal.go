@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"sync"
+)
+
+// RemovalReason tells why an item left the cache.
+type RemovalReason int
+
+const (
+	/** item is removed to keep the cache within capacity or max cost */
+	RemovalEvicted RemovalReason = iota + 1
+	RemovalExpired
+	RemovalDeleted
+	RemovalReplaced
+)
+
+func (r RemovalReason) String() string {
+	switch r {
+	case RemovalEvicted:
+		return "evicted"
+	case RemovalExpired:
+		return "expired"
+	case RemovalDeleted:
+		return "deleted"
+	case RemovalReplaced:
+		return "replaced"
+	default:
+		return "unknown"
+	}
+}
+
+type removedItem[K comparable, T any] struct {
+	key    K
+	value  T
+	reason RemovalReason
+}
+
+// removalNotifier run the callback in its own goroutine. Removed items are queued in a slice that grow as
+// needed, so a slow callback delay the notification but never block Set or Get.
+type removalNotifier[K comparable, T any] struct {
+	sync.Mutex
+	onEvict func(K, T, RemovalReason)
+	pending []removedItem[K, T]
+	wakeCh  chan struct{}
+	done    chan int
+}
+
+func newRemovalNotifier[K comparable, T any](onEvict func(K, T, RemovalReason), done chan int) *removalNotifier[K, T] {
+	if onEvict == nil {
+		return nil
+	}
+	n := &removalNotifier[K, T]{
+		onEvict: onEvict,
+		wakeCh:  make(chan struct{}, 1),
+		done:    done,
+	}
+	go n.process()
+	return n
+}
+
+func (n *removalNotifier[K, T]) notify(key K, value T, reason RemovalReason) {
+	if n == nil {
+		return
+	}
+	n.Lock()
+	n.pending = append(n.pending, removedItem[K, T]{key: key, value: value, reason: reason})
+	n.Unlock()
+	select {
+	case n.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (n *removalNotifier[K, T]) flush() {
+	n.Lock()
+	items := n.pending
+	n.pending = nil
+	n.Unlock()
+	for _, item := range items {
+		n.onEvict(item.key, item.value, item.reason)
+	}
+}
+
+func (n *removalNotifier[K, T]) process() {
+	for {
+		select {
+		case <-n.wakeCh:
+			n.flush()
+		case <-n.done:
+			n.flush()
+			return
+		}
+	}
+}
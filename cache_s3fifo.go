@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// S3-FIFO. A new key goes in the small FIFO, which is about 10% of the cache. A key that reach the end of
+// small without any read is evicted and remembered in the ghost FIFO, a key that was read moves to main.
+// A key in the ghost that is set again goes straight to main. Main is a FIFO too, a key at its end that
+// was read goes back to the front with one read less, otherwise it is evicted. The read count is 2 bits.
+//
+// A read only increment the counter of the key with an atomic, so the policy is called directly from Get
+// under its own read lock and the cache does not batch the reads or run process() for it.
+type s3fifoPolicy[K comparable] struct {
+	sync.RWMutex
+	small      *list.List
+	main       *list.List
+	ghost      *list.List
+	items      map[K]*list.Element
+	ghostItems map[K]*list.Element
+	smallCost  int64
+	cost       int64
+}
+
+type s3fifoItem[K comparable] struct {
+	key   K
+	cost  int64
+	freq  uint32
+	small bool
+}
+
+const (
+	s3fifoSmallPercent = 10
+	s3fifoMaxFreq      = 3
+)
+
+// NewS3FIFOPolicy is the S3-FIFO policy. It needs no capacity, small is kept at 10% of the cost of the
+// keys in the policy.
+func NewS3FIFOPolicy[K comparable]() EvictionPolicy[K] {
+	return &s3fifoPolicy[K]{
+		small:      list.New(),
+		main:       list.New(),
+		ghost:      list.New(),
+		items:      make(map[K]*list.Element),
+		ghostItems: make(map[K]*list.Element),
+	}
+}
+
+/** marker of the policy that can be called for the reads without the cache lock */
+func (p *s3fifoPolicy[K]) concurrentAccess() {}
+
+func (p *s3fifoPolicy[K]) OnAccess(key K, hash uint64) {
+	p.RLock()
+	defer p.RUnlock()
+	e, ok := p.items[key]
+	if !ok {
+		return
+	}
+	item := e.Value.(*s3fifoItem[K])
+	for {
+		freq := atomic.LoadUint32(&item.freq)
+		if freq >= s3fifoMaxFreq || atomic.CompareAndSwapUint32(&item.freq, freq, freq+1) {
+			return
+		}
+	}
+}
+
+func (p *s3fifoPolicy[K]) OnInsert(key K, hash uint64, cost int64) {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.items[key]; ok {
+		return
+	}
+	item := &s3fifoItem[K]{key: key, cost: cost}
+	if g, ok := p.ghostItems[key]; ok {
+		p.ghost.Remove(g)
+		delete(p.ghostItems, key)
+		p.items[key] = p.main.PushFront(item)
+	} else {
+		item.small = true
+		p.items[key] = p.small.PushFront(item)
+		p.smallCost += cost
+	}
+	p.cost += cost
+}
+
+func (p *s3fifoPolicy[K]) OnRemove(key K) {
+	p.Lock()
+	defer p.Unlock()
+	if e, ok := p.items[key]; ok {
+		p.remove(e)
+	}
+}
+
+func (p *s3fifoPolicy[K]) remove(e *list.Element) {
+	item := e.Value.(*s3fifoItem[K])
+	if item.small {
+		p.small.Remove(e)
+		p.smallCost -= item.cost
+	} else {
+		p.main.Remove(e)
+	}
+	p.cost -= item.cost
+	delete(p.items, item.key)
+}
+
+func (p *s3fifoPolicy[K]) Victim() (K, bool) {
+	p.Lock()
+	defer p.Unlock()
+	for {
+		if p.small.Len() > 0 && (p.smallCost*100 >= p.cost*s3fifoSmallPercent || p.main.Len() == 0) {
+			e := p.small.Back()
+			item := e.Value.(*s3fifoItem[K])
+			p.remove(e)
+			if atomic.LoadUint32(&item.freq) > 0 {
+				item.small = false
+				atomic.StoreUint32(&item.freq, 0)
+				p.items[item.key] = p.main.PushFront(item)
+				p.cost += item.cost
+				continue
+			}
+			p.addGhost(item.key)
+			return item.key, true
+		}
+		e := p.main.Back()
+		if e == nil {
+			var key K
+			return key, false
+		}
+		item := e.Value.(*s3fifoItem[K])
+		if freq := atomic.LoadUint32(&item.freq); freq > 0 {
+			atomic.StoreUint32(&item.freq, freq-1)
+			p.main.MoveToFront(e)
+			continue
+		}
+		p.remove(e)
+		return item.key, true
+	}
+}
+
+/** the ghost remember as many keys as the policy has */
+func (p *s3fifoPolicy[K]) addGhost(key K) {
+	p.ghostItems[key] = p.ghost.PushFront(key)
+	for p.ghost.Len() > max(1, len(p.items)) {
+		delete(p.ghostItems, p.ghost.Remove(p.ghost.Back()).(K))
+	}
+}
@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+/** The snapshot is a gob stream of a header and then one entry per live item. Keys and values are encoded
+with the codecs of the config, so the entries do not depend on the types of the cache. */
+
+const snapshotVersion = 1
+
+type snapshotHeader struct {
+	Version     int
+	Frequencies bool
+}
+
+type snapshotEntry struct {
+	Key   []byte
+	Value []byte
+	/** time left till the item expire, zero when the item never expire */
+	TTL time.Duration
+	/** ttl the item was set with, a refresh of the restored item set the new value with it */
+	SetTTL time.Duration
+	Idle   time.Duration
+	/** how long ago the item was written, RefreshAfter count from it */
+	Age  time.Duration
+	Freq uint64
+}
+
+// Snapshot write the live items of the cache with their remaining ttl to w. When SnapshotFrequencies is set
+// the frequencies of the policy are saved too, so the restored cache evict like the old one. Items set
+// during the snapshot may or may not be saved.
+func (c *KeyCache[K, V]) Snapshot(w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	header := snapshotHeader{Version: snapshotVersion, Frequencies: c.snapshotFreq}
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+	now := time.Now()
+	var err error
+	c.data.RangeItems(func(key K, item *cacheItem[V]) bool {
+		entry := snapshotEntry{
+			SetTTL: item.ttl,
+			Idle:   item.idle,
+			Age:    now.Sub(item.written),
+		}
+		if !item.expiration.IsZero() {
+			entry.TTL = item.expiration.Sub(now)
+			if entry.TTL <= 0 {
+				return true
+			}
+		}
+		if entry.Key, err = c.keyCodec.Encode(key); err != nil {
+			return false
+		}
+		if entry.Value, err = c.valueCodec.Encode(item.item); err != nil {
+			return false
+		}
+		if c.snapshotFreq {
+			entry.Freq, _ = c.data.Frequency(key, c.hash(key))
+		}
+		err = enc.Encode(&entry)
+		return err == nil
+	})
+	return err
+}
+
+// Restore set the items of a snapshot written by Snapshot. An item keep the ttl it had left when it was
+// saved, the time between the snapshot and the restore is not counted. Items already in the cache are
+// replaced. The whole snapshot is decoded before any item is set, so a broken snapshot change nothing.
+func (c *KeyCache[K, V]) Restore(r io.Reader) error {
+	dec := gob.NewDecoder(r)
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return err
+	}
+	if header.Version != snapshotVersion {
+		return fmt.Errorf("unknown snapshot version %v", header.Version)
+	}
+	items := make([]restoredItem[K, V], 0)
+	for {
+		var entry snapshotEntry
+		err := dec.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		item, err := c.restoreEntry(&entry)
+		if err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+	for _, item := range items {
+		c.data.Set(item.key, item.hash, item.item)
+		if header.Frequencies && item.freq > 0 {
+			c.data.SetFrequency(item.key, item.hash, item.freq)
+		}
+	}
+	return nil
+}
+
+type restoredItem[K comparable, V any] struct {
+	key  K
+	hash uint64
+	item *cacheItem[V]
+	freq uint64
+}
+
+func (c *KeyCache[K, V]) restoreEntry(entry *snapshotEntry) (restoredItem[K, V], error) {
+	var restored restoredItem[K, V]
+	key, err := c.keyCodec.Decode(entry.Key)
+	if err != nil {
+		return restored, err
+	}
+	value, err := c.valueCodec.Decode(entry.Value)
+	if err != nil {
+		return restored, err
+	}
+	cost := c.itemCost(value)
+	if err := c.checkCost(cost); err != nil {
+		return restored, err
+	}
+	now := time.Now()
+	item := &cacheItem[V]{
+		item:       value,
+		cost:       cost,
+		idle:       entry.Idle,
+		lastAccess: now.UnixNano(),
+		ttl:        entry.SetTTL,
+		written:    now.Add(-entry.Age),
+	}
+	if entry.TTL > 0 {
+		item.expiration = now.Add(entry.TTL)
+	}
+	return restoredItem[K, V]{key: key, hash: c.hash(key), item: item, freq: entry.Freq}, nil
+}
+
+// restoreFile restore the snapshot at path, a missing file is not an error.
+func (c *KeyCache[K, V]) restoreFile(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return c.Restore(file)
+}
+
+// snapshotFile write the snapshot to a temp file and rename it to path, so a failed snapshot never
+// replace the last good one.
+func (c *KeyCache[K, V]) snapshotFile(path string) error {
+	tempPath := path + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	if err := c.Snapshot(file); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
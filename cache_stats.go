@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"sync/atomic"
+)
+
+// Stats is a point in time snapshot of the cache counters.
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+	/** the key was found but had expired, these reads are counted in Misses too */
+	ExpiredOnRead uint64
+	/** reads that returned an expired value in the stale window, these reads are counted in Hits too */
+	StaleHits    uint64
+	Evictions    uint64
+	Expirations  uint64
+	Deletions    uint64
+	Replacements uint64
+	Size         int64
+	Cost         int64
+	/** frequency batches dropped because process() was busy, the access in them were never counted */
+	FreqBatchDrops uint64
+	/** times the frequency sketch was aged, every aging halve all the frequencies */
+	SketchResets uint64
+	/** background reloads of stale items and items older than RefreshAfter */
+	Refreshes       uint64
+	RefreshFailures uint64
+	/** number of keys in every shard of the cache, an uneven spread means the hash of the keys is poor */
+	ShardSizes []int
+}
+
+func (s Stats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+/** Read counters live in each shard so parallel readers of different shards don't write the same counter. */
+type shardStats struct {
+	hits          uint64
+	misses        uint64
+	expiredOnRead uint64
+	staleHits     uint64
+}
+
+type keyCacheStats struct {
+	refreshes       uint64
+	refreshFailures uint64
+}
+
+type cacheStats struct {
+	removals       [RemovalReplaced + 1]uint64
+	freqBatchDrops uint64
+}
+
+func (s *cacheStats) addRemoval(reason RemovalReason) {
+	atomic.AddUint64(&s.removals[reason], 1)
+}
+
+func (c *CacheData[K, T]) Stats() Stats {
+	s := Stats{
+		Evictions:      atomic.LoadUint64(&c.stats.removals[RemovalEvicted]),
+		Expirations:    atomic.LoadUint64(&c.stats.removals[RemovalExpired]),
+		Deletions:      atomic.LoadUint64(&c.stats.removals[RemovalDeleted]),
+		Replacements:   atomic.LoadUint64(&c.stats.removals[RemovalReplaced]),
+		Size:           atomic.LoadInt64(&c.size),
+		Cost:           atomic.LoadInt64(&c.cost),
+		FreqBatchDrops: atomic.LoadUint64(&c.stats.freqBatchDrops),
+	}
+	if p, ok := c.policy.(sketchPolicy); ok {
+		s.SketchResets = p.sketchResets()
+	}
+	s.ShardSizes = make([]int, len(c.data))
+	for i, shard := range c.data {
+		shard.RLock()
+		s.ShardSizes[i] = len(shard.dataMap)
+		shard.RUnlock()
+		s.Hits += atomic.LoadUint64(&shard.stats.hits)
+		s.Misses += atomic.LoadUint64(&shard.stats.misses)
+		s.ExpiredOnRead += atomic.LoadUint64(&shard.stats.expiredOnRead)
+		s.StaleHits += atomic.LoadUint64(&shard.stats.staleHits)
+	}
+	return s
+}
+
+func (c *KeyCache[K, V]) Stats() Stats {
+	s := c.data.Stats()
+	s.Refreshes = atomic.LoadUint64(&c.stats.refreshes)
+	s.RefreshFailures = atomic.LoadUint64(&c.stats.refreshFailures)
+	return s
+}
+
+/*
+* Counters of cacheRing and bigCacheRing, reported with the same Stats as Cache. The size is stored by the
+writer after every change of the map, so Stats never read the map that is written without lock.
+*/
+type ringStats struct {
+	hits     uint64
+	misses   uint64
+	size     int64
+	removals [RemovalReplaced + 1]uint64
+}
+
+func (s *ringStats) setSize(size int) {
+	atomic.StoreInt64(&s.size, int64(size))
+}
+
+func (s *ringStats) hit() {
+	atomic.AddUint64(&s.hits, 1)
+}
+
+func (s *ringStats) miss() {
+	atomic.AddUint64(&s.misses, 1)
+}
+
+func (s *ringStats) addRemoval(reason RemovalReason) {
+	atomic.AddUint64(&s.removals[reason], 1)
+}
+
+func (s *ringStats) snapshot() Stats {
+	size := atomic.LoadInt64(&s.size)
+	return Stats{
+		Hits:         atomic.LoadUint64(&s.hits),
+		Misses:       atomic.LoadUint64(&s.misses),
+		Evictions:    atomic.LoadUint64(&s.removals[RemovalEvicted]),
+		Deletions:    atomic.LoadUint64(&s.removals[RemovalDeleted]),
+		Replacements: atomic.LoadUint64(&s.removals[RemovalReplaced]),
+		Size:         size,
+		Cost:         size,
+	}
+}
+
+func (c *cacheRing[T]) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+/** Size of bigCacheRing is the number of keys saved in file, hits count both the memory and the file hits. */
+func (c *BigCache[T]) Stats() Stats {
+	return c.stats.snapshot()
+}
@@ -0,0 +1,1247 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestCache[T any](capacity uint64) *Cache[T] {
+	return NewCacheWithCapacity[T](&CacheConfig[string, T]{
+		Capacity:   capacity,
+		CountBatch: 5,
+		FreqCount:  100,
+	}, make(chan int))
+}
+
+func TestCacheGetOrLoad(t *testing.T) {
+	c := newTestCache[int](100)
+	defer c.Close()
+
+	var loads int32
+	loader := func(key string) (int, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(50 * time.Millisecond)
+		return 7, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := c.GetOrLoad("key", time.Minute, loader)
+			if err != nil || val != 7 {
+				t.Errorf("unexpected load result %v %v", val, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if loads != 1 {
+		t.Fatalf("loader called %v times, expected 1", loads)
+	}
+	val, err := c.Get("key")
+	if err != nil || val != 7 {
+		t.Fatalf("loaded value should be stored in cache, got %v %v", val, err)
+	}
+}
+
+func TestCacheGetOrLoadError(t *testing.T) {
+	c := newTestCache[int](100)
+	defer c.Close()
+
+	loadErr := errors.New("backend down")
+	var loads int32
+	loader := func(key string) (int, error) {
+		atomic.AddInt32(&loads, 1)
+		return 0, loadErr
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetOrLoad("key", time.Minute, loader); err != loadErr {
+			t.Fatalf("expected loader error, got %v", err)
+		}
+	}
+	if loads != 3 {
+		t.Fatalf("loader errors should not be cached by default, loader called %v times", loads)
+	}
+	if _, err := c.Get("key"); err == nil {
+		t.Fatalf("failed load should not store a value")
+	}
+
+	ce := NewCacheWithCapacity[int](&CacheConfig[string, int]{
+		Capacity:     100,
+		CountBatch:   5,
+		FreqCount:    100,
+		LoadErrorTTL: time.Minute,
+	}, make(chan int))
+	defer ce.Close()
+	loads = 0
+	for i := 0; i < 3; i++ {
+		if _, err := ce.GetOrLoad("key", time.Minute, loader); err != loadErr {
+			t.Fatalf("expected loader error, got %v", err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("loader error should be cached, loader called %v times", loads)
+	}
+}
+
+func TestKeyCacheCollision(t *testing.T) {
+	c := NewKeyCacheWithCapacity[string, int](&CacheConfig[string, int]{
+		Capacity:   100,
+		CountBatch: 5,
+		FreqCount:  100,
+		/** every key has the same hash, so only the stored key can tell them apart */
+		KeyHash: func(string) uint64 { return 1 },
+	}, make(chan int))
+	defer c.Close()
+
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Minute)
+	if val, err := c.Get("a"); err != nil || val != 1 {
+		t.Fatalf("expected value 1 for key a, got %v %v", val, err)
+	}
+	if val, err := c.Get("b"); err != nil || val != 2 {
+		t.Fatalf("expected value 2 for key b, got %v %v", val, err)
+	}
+	c.Delete("a")
+	if _, err := c.Get("a"); err == nil {
+		t.Fatalf("key a is deleted so no value should be present")
+	}
+	if val, err := c.Get("b"); err != nil || val != 2 {
+		t.Fatalf("deleting key a should not remove key b, got %v %v", val, err)
+	}
+}
+
+func TestKeyCacheKeyTypes(t *testing.T) {
+	type userKey struct {
+		tenant string
+		id     int
+	}
+	uc := NewKeyCacheWithCapacity[userKey, string](&CacheConfig[userKey, string]{
+		Capacity:   100,
+		CountBatch: 5,
+		FreqCount:  100,
+	}, make(chan int))
+	defer uc.Close()
+	uc.Set(userKey{tenant: "a", id: 1}, "a1", time.Minute)
+	uc.Set(userKey{tenant: "b", id: 1}, "b1", time.Minute)
+	if val, err := uc.Get(userKey{tenant: "a", id: 1}); err != nil || val != "a1" {
+		t.Fatalf("expected a1, got %v %v", val, err)
+	}
+	if val, err := uc.Get(userKey{tenant: "b", id: 1}); err != nil || val != "b1" {
+		t.Fatalf("expected b1, got %v %v", val, err)
+	}
+
+	/** keys that are == hash the same, also when a field is -0.0 or is nested in an array or an interface */
+	type pointKey struct {
+		x     float64
+		tags  [2]any
+		owner *userKey
+	}
+	owner := &userKey{tenant: "a"}
+	negZero := math.Copysign(0, -1)
+	if keyHash(pointKey{x: 0, tags: [2]any{0.0, "t"}, owner: owner}) != keyHash(pointKey{x: negZero, tags: [2]any{negZero, "t"}, owner: owner}) {
+		t.Fatalf("equal struct keys should have the same hash")
+	}
+	if keyHash(pointKey{x: 1, owner: owner}) == keyHash(pointKey{x: 1, owner: &userKey{tenant: "a"}}) {
+		t.Fatalf("pointer fields should be hashed by address")
+	}
+	pc := NewKeyCacheWithCapacity[pointKey, string](&CacheConfig[pointKey, string]{
+		Capacity:   100,
+		CountBatch: 5,
+		Shards:     64,
+	}, make(chan int))
+	defer pc.Close()
+	pc.Set(pointKey{x: 0}, "origin", time.Minute)
+	if val, err := pc.Get(pointKey{x: negZero}); err != nil || val != "origin" {
+		t.Fatalf("expected origin for -0.0, got %v %v", val, err)
+	}
+
+	ic := NewKeyCacheWithCapacity[int, string](&CacheConfig[int, string]{
+		Capacity:   100,
+		CountBatch: 5,
+		FreqCount:  100,
+	}, make(chan int))
+	defer ic.Close()
+	ic.Set(42, "answer", time.Minute)
+	if val, err := ic.Get(42); err != nil || val != "answer" {
+		t.Fatalf("expected answer, got %v %v", val, err)
+	}
+	if _, err := ic.Get(43); err == nil {
+		t.Fatalf("key 43 was never set")
+	}
+}
+
+func TestCacheMaxCost(t *testing.T) {
+	c := NewCacheWithCapacity[string](&CacheConfig[string, string]{
+		CountBatch: 1,
+		FreqCount:  100,
+		MaxCost:    100,
+		Cost:       func(v string) int64 { return int64(len(v)) },
+	}, make(chan int))
+	defer c.Close()
+
+	if err := c.SetWithCost("big", "v", 101, time.Minute); err == nil {
+		t.Fatalf("item with cost more than max cost should be rejected")
+	}
+	value := string(make([]byte, 30))
+	c.Set("a", value, time.Minute)
+	c.Set("b", value, time.Minute)
+	c.Set("c", value, time.Minute)
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		c.Get("a")
+		c.Get("c")
+	}
+	time.Sleep(20 * time.Millisecond)
+	c.Set("d", value, time.Minute)
+
+	data := c.data.(*CacheData[string, string])
+	if cost := data.cost; cost > 100 {
+		t.Fatalf("total cost %v is more than max cost", cost)
+	}
+	if _, err := c.Get("a"); err != nil {
+		t.Fatalf("frequently used key a should not be evicted %v", err)
+	}
+	if _, err := c.Get("b"); err == nil {
+		t.Fatalf("least frequently used key b should be evicted")
+	}
+}
+
+func TestCacheAdmissionScan(t *testing.T) {
+	c := NewCacheWithCapacity[int](&CacheConfig[string, int]{
+		Capacity:   100,
+		CountBatch: 10,
+		FreqCount:  1000,
+	}, make(chan int))
+	defer c.Close()
+
+	for i := 0; i < 20; i++ {
+		c.Set(fmt.Sprintf("hot-%v", i), i, time.Minute)
+	}
+	for j := 0; j < 4; j++ {
+		for i := 0; i < 20; i++ {
+			c.Get(fmt.Sprintf("hot-%v", i))
+		}
+	}
+	c.data.(*CacheData[string, int]).drainAccesses()
+	for i := 0; i < 200; i++ {
+		c.Set(fmt.Sprintf("cold-%v", i), i, time.Minute)
+	}
+
+	data := c.data.(*CacheData[string, int])
+	if data.size > 100 {
+		t.Fatalf("cache size %v is more than capacity", data.size)
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := c.Get(fmt.Sprintf("hot-%v", i)); err != nil {
+			t.Fatalf("hot key %v should not be evicted by the scan %v", i, err)
+		}
+	}
+}
+
+func TestCacheOnEvict(t *testing.T) {
+	type removal struct {
+		key    string
+		value  int
+		reason RemovalReason
+	}
+	removedCh := make(chan removal, 10)
+	c := NewCacheWithCapacity[int](&CacheConfig[string, int]{
+		Capacity:   2,
+		CountBatch: 5,
+		FreqCount:  100,
+		OnEvict: func(key string, value int, reason RemovalReason) {
+			removedCh <- removal{key: key, value: value, reason: reason}
+		},
+	}, make(chan int))
+	defer c.Close()
+	expect := func(want removal) {
+		t.Helper()
+		select {
+		case got := <-removedCh:
+			if got != want {
+				t.Fatalf("expected removal %v, got %v", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no removal callback for %v", want)
+		}
+	}
+
+	c.Set("a", 1, time.Minute)
+	c.Set("a", 2, time.Minute)
+	expect(removal{key: "a", value: 1, reason: RemovalReplaced})
+	c.Delete("a")
+	expect(removal{key: "a", value: 2, reason: RemovalDeleted})
+
+	c.Set("b", 3, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	c.data.(*CacheData[string, int]).delExpired("b", c.hash("b"))
+	expect(removal{key: "b", value: 3, reason: RemovalExpired})
+
+	c.Set("c", 4, time.Minute)
+	c.Set("d", 5, time.Minute)
+	c.Set("e", 6, time.Minute)
+	select {
+	case got := <-removedCh:
+		if got.reason != RemovalEvicted {
+			t.Fatalf("expected eviction, got %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no removal callback for capacity eviction")
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	c := newTestCache[int](2)
+	defer c.Close()
+
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	c.Get("a")
+	c.Get("a")
+	c.Get("b")
+	c.Get("c")
+	c.Set("a", 3, time.Minute)
+	c.Delete("a")
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.ExpiredOnRead != 1 {
+		t.Fatalf("unexpected read stats %+v", stats)
+	}
+	if stats.Replacements != 1 || stats.Deletions != 1 {
+		t.Fatalf("unexpected removal stats %+v", stats)
+	}
+	if stats.Size != 1 {
+		t.Fatalf("expected size 1, got %v", stats.Size)
+	}
+	if stats.HitRatio() != 0.5 {
+		t.Fatalf("expected hit ratio 0.5, got %v", stats.HitRatio())
+	}
+}
+
+func TestCacheIteration(t *testing.T) {
+	c := newTestCache[int](100)
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprint(i), i, time.Minute)
+	}
+	c.Set("expired", -1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if c.Len() != 10 {
+		t.Fatalf("expected 10 live items, got %v", c.Len())
+	}
+	seen := make(map[string]int)
+	for key, value := range c.All() {
+		seen[key] = value
+	}
+	if len(seen) != 10 {
+		t.Fatalf("expected 10 items from All, got %v", len(seen))
+	}
+	for i := 0; i < 10; i++ {
+		if seen[fmt.Sprint(i)] != i {
+			t.Fatalf("wrong value for key %v", i)
+		}
+	}
+	count := 0
+	for range c.Keys() {
+		count++
+		if count == 3 {
+			break
+		}
+	}
+	if count != 3 {
+		t.Fatalf("iteration should stop on break")
+	}
+
+	r := NewCacheRing[int](5, RingClock)
+	r.Set("a", 1)
+	r.Set("b", 2)
+	ringItems := make(map[string]int)
+	for key, value := range r.All() {
+		ringItems[key] = value
+	}
+	if r.Len() != 2 || ringItems["a"] != 1 || ringItems["b"] != 2 {
+		t.Fatalf("unexpected ring items %v", ringItems)
+	}
+}
+
+func TestCacheBatch(t *testing.T) {
+	c := newTestCache[int](1000)
+	defer c.Close()
+
+	items := make(map[string]int)
+	keys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		items[fmt.Sprint(i)] = i
+		keys = append(keys, fmt.Sprint(i))
+	}
+	if err := c.SetMany(items, time.Minute); err != nil {
+		t.Fatalf("failed to set items %v", err)
+	}
+	values := c.GetMany(append(keys, "missing"))
+	if len(values) != 100 {
+		t.Fatalf("expected 100 values, got %v", len(values))
+	}
+	for key, value := range items {
+		if values[key] != value {
+			t.Fatalf("wrong value for key %v", key)
+		}
+	}
+	c.DeleteMany(keys[:50])
+	if values := c.GetMany(keys); len(values) != 50 {
+		t.Fatalf("expected 50 values after delete, got %v", len(values))
+	}
+	stats := c.Stats()
+	if stats.Size != 50 || stats.Deletions != 50 || stats.Hits != 150 || stats.Misses != 51 {
+		t.Fatalf("unexpected stats after batch operations %+v", stats)
+	}
+}
+
+func TestCacheSlidingExpiration(t *testing.T) {
+	c := newTestCache[int](100)
+	defer c.Close()
+
+	c.SetWithIdle("session", 1, 200*time.Millisecond, 0)
+	c.SetWithIdle("bounded", 2, 200*time.Millisecond, 100*time.Millisecond)
+	for i := 0; i < 8; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if _, err := c.Get("session"); err != nil {
+			t.Fatalf("session is read before idle timeout, it should not expire %v", err)
+		}
+		c.Get("bounded")
+	}
+	if _, err := c.Get("bounded"); err == nil {
+		t.Fatalf("item should expire after max ttl even if it is read")
+	}
+	data := c.data.(*CacheData[string, int])
+	data.delExpired("session", c.hash("session"))
+	if _, err := c.Get("session"); err != nil {
+		t.Fatalf("cleanup should not remove the item that was read %v", err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if _, err := c.Get("session"); err == nil {
+		t.Fatalf("session should expire when it is not read for idle timeout")
+	}
+	data.delExpired("session", c.hash("session"))
+	data.delExpired("bounded", c.hash("bounded"))
+	if stats := c.Stats(); stats.Size != 0 || stats.Expirations != 2 {
+		t.Fatalf("expired items should be removed, stats %+v", c.Stats())
+	}
+}
+
+func TestCacheTTLPolicy(t *testing.T) {
+	c := NewCacheWithCapacity[int](&CacheConfig[string, int]{
+		Capacity:   100,
+		CountBatch: 5,
+		FreqCount:  100,
+		DefaultTTL: 50 * time.Millisecond,
+		MaxTTL:     300 * time.Millisecond,
+	}, make(chan int))
+	defer c.Close()
+
+	c.Set("forever", 1, NoExpiration)
+	c.Set("default", 2, 0)
+	c.Set("capped", 3, time.Hour)
+	time.Sleep(100 * time.Millisecond)
+	if _, err := c.Get("default"); err == nil {
+		t.Fatalf("item with zero ttl should expire after default ttl")
+	}
+	if _, err := c.Get("capped"); err != nil {
+		t.Fatalf("capped item should live till max ttl %v", err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if _, err := c.Get("capped"); err == nil {
+		t.Fatalf("item ttl should be capped by max ttl")
+	}
+	if _, err := c.Get("forever"); err != nil {
+		t.Fatalf("item with no expiration should not expire %v", err)
+	}
+
+	p := ttlPolicy{jitter: 0.5}
+	now := time.Now()
+	wheel := newExpirationData[string](5*time.Second, 0)
+	buckets := make(map[int64]bool)
+	for i := 0; i < 100; i++ {
+		expiration := p.expiration(now, 100*time.Second)
+		if expiration.After(now.Add(100*time.Second)) || expiration.Before(now.Add(50*time.Second)) {
+			t.Fatalf("jitter moved expiration out of range %v", expiration.Sub(now))
+		}
+		buckets[wheel.tickOf(expiration)] = true
+	}
+	if len(buckets) < 2 {
+		t.Fatalf("jitter should spread the items over expiration buckets")
+	}
+}
+
+// waitLoad wait until the load of the key, started by a read in background, is finished.
+func waitLoad[K comparable, V any](t *testing.T, c *KeyCache[K, V], key K) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		c.loads.Lock()
+		_, running := c.loads.calls[key]
+		c.loads.Unlock()
+		if !running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("load of %v is not finished", key)
+		}
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	c := NewCacheWithCapacity[int](&CacheConfig[string, int]{
+		Capacity:    100,
+		CountBatch:  5,
+		FreqCount:   100,
+		StaleWindow: 200 * time.Millisecond,
+		Loader: func(key string) (int, error) {
+			atomic.AddInt32(&loads, 1)
+			<-release
+			return 2, nil
+		},
+	}, make(chan int))
+	defer c.Close()
+
+	c.Set("a", 1, 60*time.Millisecond)
+	time.Sleep(70 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		value, stale, err := c.GetWithStale("a")
+		if err != nil || !stale || value != 1 {
+			t.Fatalf("expected stale value 1, got %v %v %v", value, stale, err)
+		}
+	}
+	close(release)
+	waitLoad(t, c.KeyCache, "a")
+	value, stale, err := c.GetWithStale("a")
+	if err != nil || stale || value != 2 {
+		t.Fatalf("expected refreshed value 2, got %v %v %v", value, stale, err)
+	}
+	if loads := atomic.LoadInt32(&loads); loads != 1 {
+		t.Fatalf("stale reads should start one refresh, loader called %v times", loads)
+	}
+	if c.Stats().StaleHits != 5 {
+		t.Fatalf("expected 5 stale hits, got %v", c.Stats().StaleHits)
+	}
+
+	nc := NewCacheWithCapacity[int](&CacheConfig[string, int]{
+		Capacity:    100,
+		CountBatch:  5,
+		FreqCount:   100,
+		StaleWindow: 200 * time.Millisecond,
+	}, make(chan int))
+	defer nc.Close()
+	nc.Set("b", 1, 10*time.Millisecond)
+	time.Sleep(15 * time.Millisecond)
+	if value, err := nc.Get("b"); err != nil || value != 1 {
+		t.Fatalf("stale value should be returned without loader, got %v %v", value, err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if _, err := nc.Get("b"); err == nil {
+		t.Fatalf("item should expire after the stale window")
+	}
+}
+
+func TestCacheRefreshAfter(t *testing.T) {
+	var loads int32
+	var fail atomic.Bool
+	c := NewCacheWithCapacity[int](&CacheConfig[string, int]{
+		Capacity:     100,
+		CountBatch:   5,
+		FreqCount:    100,
+		RefreshAfter: 20 * time.Millisecond,
+		Loader: func(key string) (int, error) {
+			if fail.Load() {
+				return 0, errors.New("backend down")
+			}
+			return int(atomic.AddInt32(&loads, 1)) + 1, nil
+		},
+	}, make(chan int))
+	defer c.Close()
+
+	c.Set("config", 1, NoExpiration)
+	if value, _ := c.Get("config"); value != 1 {
+		t.Fatalf("fresh item should not be refreshed, got %v", value)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if value, _ := c.Get("config"); value != 1 {
+		t.Fatalf("reader should get the current value while it is refreshed, got %v", value)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if value, _ := c.Get("config"); value != 2 {
+		t.Fatalf("expected refreshed value 2, got %v", value)
+	}
+
+	fail.Store(true)
+	time.Sleep(30 * time.Millisecond)
+	c.Get("config")
+	time.Sleep(10 * time.Millisecond)
+	if value, err := c.Get("config"); err != nil || value != 2 {
+		t.Fatalf("failed refresh should keep the old value, got %v %v", value, err)
+	}
+	stats := c.Stats()
+	if stats.Refreshes != 1 || stats.RefreshFailures < 1 {
+		t.Fatalf("unexpected refresh stats %+v", stats)
+	}
+
+	/** a delete or a set during the refresh win over the loaded value */
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	rc := NewCacheWithCapacity[int](&CacheConfig[string, int]{
+		Capacity:     100,
+		CountBatch:   5,
+		RefreshAfter: time.Hour,
+		Loader: func(key string) (int, error) {
+			started <- struct{}{}
+			<-release
+			return 10, nil
+		},
+	}, make(chan int))
+	defer rc.Close()
+	rc.Set("deleted", 1, NoExpiration)
+	rc.Set("set", 1, NoExpiration)
+	/** only the two items written an hour ago are refreshed, the items set by the test are not */
+	for _, key := range []string{"deleted", "set"} {
+		item, _, _ := rc.data.Get(key, rc.hash(key))
+		item.written = time.Now().Add(-2 * time.Hour)
+	}
+	rc.Get("deleted")
+	<-started
+	rc.Delete("deleted")
+	rc.Get("set")
+	<-started
+	rc.Set("set", 3, NoExpiration)
+	close(release)
+	waitLoad(t, rc.KeyCache, "deleted")
+	waitLoad(t, rc.KeyCache, "set")
+	if _, err := rc.Get("deleted"); err == nil {
+		t.Fatalf("refresh should not add back a deleted key")
+	}
+	if value, err := rc.Get("set"); err != nil || value != 3 {
+		t.Fatalf("refresh should not overwrite a newer set, got %v %v", value, err)
+	}
+	if refreshes := rc.Stats().Refreshes; refreshes != 0 {
+		t.Fatalf("dropped refreshes should not be counted, got %v", refreshes)
+	}
+
+	/** a refresh loader that panic is counted as a failed refresh */
+	item, _, _ := rc.data.Get("set", rc.hash("set"))
+	rc.refresh("set", item, func(key string) (int, error) {
+		panic("loader bug")
+	})
+	waitLoad(t, rc.KeyCache, "set")
+	if failures := rc.Stats().RefreshFailures; failures != 1 {
+		t.Fatalf("panicking refresh should be counted as a failure, got %v", failures)
+	}
+	if value, err := rc.Get("set"); err != nil || value != 3 {
+		t.Fatalf("panicking refresh should keep the value, got %v %v", value, err)
+	}
+}
+
+func TestCacheGetOrLoadCtx(t *testing.T) {
+	c := newTestCache[int](100)
+	defer c.Close()
+
+	clientGone := errors.New("client gone")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	loaderCause := make(chan error, 1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel(clientGone)
+	}()
+	_, err := c.GetOrLoadCtx(ctx, "a", time.Minute, func(loadCtx context.Context, key string) (int, error) {
+		<-loadCtx.Done()
+		loaderCause <- context.Cause(loadCtx)
+		return 0, loadCtx.Err()
+	})
+	if err != clientGone {
+		t.Fatalf("expected the cancel cause as error, got %v", err)
+	}
+	select {
+	case cause := <-loaderCause:
+		if cause != clientGone {
+			t.Fatalf("loader context should be cancelled with the cause, got %v", cause)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("loader context is not cancelled after the only caller left")
+	}
+
+	/** one caller leave, the other still get the loaded value */
+	leaveCtx, leave := context.WithCancel(context.Background())
+	loader := func(loadCtx context.Context, key string) (int, error) {
+		time.Sleep(50 * time.Millisecond)
+		if loadCtx.Err() != nil {
+			return 0, loadCtx.Err()
+		}
+		return 5, nil
+	}
+	leaveErr := make(chan error, 1)
+	go func() {
+		_, err := c.GetOrLoadCtx(leaveCtx, "b", time.Minute, loader)
+		leaveErr <- err
+	}()
+	type result struct {
+		value int
+		err   error
+	}
+	stayResult := make(chan result, 1)
+	time.Sleep(5 * time.Millisecond)
+	go func() {
+		value, err := c.GetOrLoadCtx(context.Background(), "b", time.Minute, loader)
+		stayResult <- result{value: value, err: err}
+	}()
+	time.Sleep(5 * time.Millisecond)
+	leave()
+	if err := <-leaveErr; err != context.Canceled {
+		t.Fatalf("caller that left should get context.Canceled, got %v", err)
+	}
+	if r := <-stayResult; r.err != nil || r.value != 5 {
+		t.Fatalf("remaining caller should get the value, got %v %v", r.value, r.err)
+	}
+
+	/** a GetOrLoad caller that join the load of a GetOrLoadCtx caller keep it running when that caller leave */
+	mixedCtx, mixedLeave := context.WithCancel(context.Background())
+	started, release := make(chan struct{}), make(chan struct{})
+	mixedErr := make(chan error, 1)
+	go func() {
+		_, err := c.GetOrLoadCtx(mixedCtx, "d", time.Minute, func(loadCtx context.Context, key string) (int, error) {
+			close(started)
+			<-release
+			if loadCtx.Err() != nil {
+				return 0, context.Cause(loadCtx)
+			}
+			return 7, nil
+		})
+		mixedErr <- err
+	}()
+	<-started
+	plainResult := make(chan result, 1)
+	go func() {
+		value, err := c.GetOrLoad("d", time.Minute, func(key string) (int, error) {
+			return 0, errors.New("loader of the joined caller should not run")
+		})
+		plainResult <- result{value: value, err: err}
+	}()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		c.loads.Lock()
+		waiters := c.loads.calls["d"].waiters
+		c.loads.Unlock()
+		if waiters == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GetOrLoad should join the running load")
+		}
+	}
+	mixedLeave()
+	if err := <-mixedErr; err != context.Canceled {
+		t.Fatalf("caller that left should get context.Canceled, got %v", err)
+	}
+	close(release)
+	if r := <-plainResult; r.err != nil || r.value != 7 {
+		t.Fatalf("GetOrLoad caller should get the loaded value, got %v %v", r.value, r.err)
+	}
+
+	/** a loader panic is recovered in the load goroutine and the waiting caller panic with its value */
+	panicked := func() (value any) {
+		defer func() {
+			value = recover()
+		}()
+		c.GetOrLoadCtx(context.Background(), "e", time.Minute, func(loadCtx context.Context, key string) (int, error) {
+			panic("loader bug")
+		})
+		return nil
+	}()
+	if panicked != "loader bug" {
+		t.Fatalf("caller should panic with the loader panic, got %v", panicked)
+	}
+
+	done, stop := context.WithCancel(context.Background())
+	stop()
+	if _, err := c.GetCtx(done, "b"); err != context.Canceled {
+		t.Fatalf("GetCtx with done context should fail, got %v", err)
+	}
+	if err := c.SetCtx(done, "c", 1, time.Minute); err != context.Canceled {
+		t.Fatalf("SetCtx with done context should fail, got %v", err)
+	}
+}
+
+func TestCachePolicy(t *testing.T) {
+	lru := NewLRUPolicy[string]()
+	clock := NewClockPolicy[string]()
+	for _, p := range []EvictionPolicy[string]{lru, clock} {
+		for _, key := range []string{"a", "b", "c"} {
+			p.OnInsert(key, keyHash(key), 1)
+		}
+		p.OnAccess("a", keyHash("a"))
+		p.OnRemove("b")
+	}
+	/** a is read after c, so c is the least recently used */
+	if key, ok := lru.Victim(); !ok || key != "c" {
+		t.Fatalf("lru victim should be c, got %v %v", key, ok)
+	}
+	/** the hand clear the reference of a and stop at c */
+	if key, ok := clock.Victim(); !ok || key != "c" {
+		t.Fatalf("clock victim should be c, got %v %v", key, ok)
+	}
+	if key, ok := clock.Victim(); !ok || key != "a" {
+		t.Fatalf("clock victim should be a after its reference is cleared, got %v %v", key, ok)
+	}
+	if _, ok := clock.Victim(); ok {
+		t.Fatalf("clock policy should be empty")
+	}
+
+	policies := map[string]EvictionPolicy[string]{
+		"lfu":   NewLFUPolicy[string](10, 0, 0, 0),
+		"lru":   NewLRUPolicy[string](),
+		"clock": NewClockPolicy[string](),
+	}
+	for name, policy := range policies {
+		c := NewCacheWithCapacity[int](&CacheConfig[string, int]{
+			Capacity:   10,
+			CountBatch: 5,
+			FreqCount:  100,
+			Policy:     policy,
+		}, make(chan int))
+		for i := 0; i < 100; i++ {
+			c.Set(fmt.Sprintf("key-%v", i), i, time.Minute)
+		}
+		stats := c.Stats()
+		if stats.Size != 10 || stats.Evictions != 90 {
+			t.Fatalf("%v policy should keep 10 keys and evict 90, got %v and %v", name, stats.Size, stats.Evictions)
+		}
+		if val, err := c.Get("key-99"); err != nil || val != 99 {
+			t.Fatalf("%v policy should keep the last key, got %v %v", name, val, err)
+		}
+		c.Close()
+	}
+}
+
+// replayTrace run the trace on the policy like a cache of capacity keys that set every missed key, and
+// return the hits. It skip the access batching of the cache, so the result does not depend on timing.
+func replayTrace(p EvictionPolicy[string], capacity int, trace []string) int {
+	cached := make(map[string]bool)
+	hits := 0
+	for _, key := range trace {
+		hash := keyHash(key)
+		p.OnAccess(key, hash)
+		if cached[key] {
+			hits++
+			continue
+		}
+		cached[key] = true
+		p.OnInsert(key, hash, 1)
+		for len(cached) > capacity {
+			victim, ok := p.Victim()
+			if !ok {
+				break
+			}
+			delete(cached, victim)
+		}
+	}
+	return hits
+}
+
+func TestCacheARCPolicy(t *testing.T) {
+	/** every phase loop over a new set of 50 keys, after the first round every round is followed by a scan of
+	100 keys that are never read again. The scan is larger than the cache, so LRU lose the loop every round. */
+	trace := []string{}
+	for phase := 0; phase < 10; phase++ {
+		for round := 0; round < 10; round++ {
+			for i := 0; i < 50; i++ {
+				trace = append(trace, fmt.Sprintf("loop-%v-%v", phase, i))
+			}
+			if round == 0 {
+				continue
+			}
+			for i := 0; i < 100; i++ {
+				trace = append(trace, fmt.Sprintf("scan-%v-%v-%v", phase, round, i))
+			}
+		}
+	}
+	arc := replayTrace(NewARCPolicy[string](100), 100, trace)
+	lfu := replayTrace(NewLFUPolicy[string](100, 0, 0, 0), 100, trace)
+	lru := replayTrace(NewLRUPolicy[string](), 100, trace)
+	if arc <= lfu || arc <= lru {
+		t.Fatalf("arc should have more hits than lfu and lru, got arc %v lfu %v lru %v", arc, lfu, lru)
+	}
+
+	c := NewCacheWithCapacity[int](&CacheConfig[string, int]{
+		Capacity:   10,
+		CountBatch: 5,
+		FreqCount:  100,
+		Policy:     NewARCPolicy[string](10),
+	}, make(chan int))
+	defer c.Close()
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprintf("key-%v", i), i, time.Minute)
+	}
+	if stats := c.Stats(); stats.Size != 10 || stats.Evictions != 90 {
+		t.Fatalf("arc policy should keep 10 keys and evict 90, got %v and %v", stats.Size, stats.Evictions)
+	}
+}
+
+func TestCacheS3FIFOPolicy(t *testing.T) {
+	p := NewS3FIFOPolicy[string]()
+	for _, key := range []string{"a", "b", "c"} {
+		p.OnInsert(key, keyHash(key), 1)
+	}
+	p.OnAccess("a", keyHash("a"))
+	/** a was read so it moves to main, b is evicted from small to the ghost */
+	if key, ok := p.Victim(); !ok || key != "b" {
+		t.Fatalf("s3fifo victim should be b, got %v %v", key, ok)
+	}
+	/** b is in the ghost, so it goes straight to main when it is set again */
+	p.OnInsert("b", keyHash("b"), 1)
+	if key, ok := p.Victim(); !ok || key != "c" {
+		t.Fatalf("s3fifo victim should be c, got %v %v", key, ok)
+	}
+	if key, ok := p.Victim(); !ok || key != "a" {
+		t.Fatalf("s3fifo victim should be a, got %v %v", key, ok)
+	}
+
+	c := NewCacheWithCapacity[int](&CacheConfig[string, int]{
+		Capacity:   100,
+		CountBatch: 5,
+		FreqCount:  100,
+		Policy:     NewS3FIFOPolicy[string](),
+	}, make(chan int))
+	defer c.Close()
+	for i := 0; i < 20; i++ {
+		c.Set(fmt.Sprintf("hot-%v", i), i, time.Minute)
+		c.Get(fmt.Sprintf("hot-%v", i))
+	}
+	/** reads are counted without process(), so no wait is needed before the scan */
+	for i := 0; i < 500; i++ {
+		c.Set(fmt.Sprintf("cold-%v", i), i, time.Minute)
+	}
+	if stats := c.Stats(); stats.Size != 100 {
+		t.Fatalf("cache size should be 100, got %v", stats.Size)
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := c.Get(fmt.Sprintf("hot-%v", i)); err != nil {
+			t.Fatalf("hot key %v should not be evicted by the scan %v", i, err)
+		}
+	}
+}
+
+func TestCacheRingSieve(t *testing.T) {
+	r := NewCacheRing[int](3, RingSieve)
+	r.Set("a", 1)
+	r.Set("b", 2)
+	r.Set("c", 3)
+	r.Get("a")
+	/** the hand start at a, a was read so the hand clear it and evict b. It then evict c and d that were
+	not read, a stay in its place at the tail */
+	r.Set("d", 4)
+	r.Set("e", 5)
+	r.Set("f", 6)
+	for _, key := range []string{"b", "c", "d"} {
+		if _, err := r.Get(key); err == nil {
+			t.Fatalf("key %v should be evicted", key)
+		}
+	}
+	for _, key := range []string{"a", "e", "f"} {
+		if _, err := r.Get(key); err != nil {
+			t.Fatalf("key %v should be in the ring %v", key, err)
+		}
+	}
+
+	/** the deleted slot is reused without evicting any key */
+	r.Delete("a")
+	r.Set("g", 7)
+	if stats := r.Stats(); r.Len() != 3 || stats.Evictions != 3 {
+		t.Fatalf("ring should have 3 keys after 3 evictions, got %v keys and %v evictions", r.Len(), stats.Evictions)
+	}
+	for _, key := range []string{"e", "f", "g"} {
+		if _, err := r.Get(key); err != nil {
+			t.Fatalf("key %v should be in the ring %v", key, err)
+		}
+	}
+}
+
+func TestCacheExpirationWheel(t *testing.T) {
+	e := newExpirationData[string](time.Millisecond, 3)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		e.add(fmt.Sprintf("soon-%v", i), 1, now.Add(5*time.Millisecond))
+	}
+	/** 100 ticks is in level 1 and one hour is in level 3 */
+	e.add("later", 1, now.Add(100*time.Millisecond))
+	e.add("hour", 1, now.Add(time.Hour))
+	e.add("moved", 1, now.Add(time.Hour))
+	e.add("moved", 1, now.Add(5*time.Millisecond))
+	e.add("never", 1, now.Add(time.Hour))
+	e.add("never", 1, time.Time{})
+	if e.count() != 8 {
+		t.Fatalf("wheel should have 8 keys, got %v", e.count())
+	}
+
+	/** the cleanup is late by many ticks, it catch up on all of them but remove only 3 keys per run */
+	time.Sleep(150 * time.Millisecond)
+	removed := make([]string, 0)
+	del := func(key string, hash uint64) {
+		removed = append(removed, key)
+	}
+	for run := 1; run <= 3; run++ {
+		e.removeExpiredItem(del)
+		if len(removed) != min(3*run, 7) {
+			t.Fatalf("run %v should remove %v keys, got %v", run, min(3*run, 7), removed)
+		}
+	}
+	e.removeExpiredItem(del)
+	if len(removed) != 7 || e.count() != 1 {
+		t.Fatalf("only the key of one hour should be left, removed %v", removed)
+	}
+
+	slots := 0
+	for level := range e.levels {
+		for _, slot := range e.levels[level] {
+			if slot != nil {
+				slots++
+			}
+		}
+	}
+	if slots != 1 || e.overflow != nil {
+		t.Fatalf("emptied slots should be freed, %v slots are left", slots)
+	}
+}
+
+func TestCacheExpirationWheelOffsets(t *testing.T) {
+	e := newExpirationData[int64](time.Millisecond, 100000)
+	/** start a few ticks before a level 2 boundary, so the keys cross the boundaries of level 1 and level 2 */
+	start := int64(2<<(2*wheelBits)) - 70
+	e.tick = start
+	for due := start + 1; due < start+3*wheelSlots*wheelSlots; due++ {
+		e.add(due, 1, time.Unix(0, (due-1)*e.resolution))
+	}
+	for tick := start + 1; tick < start+3*wheelSlots*wheelSlots; tick++ {
+		removed := make([]int64, 0)
+		e.expireUntil(tick, func(key int64, hash uint64) {
+			removed = append(removed, key)
+		})
+		if len(removed) != 1 || removed[0] != tick {
+			t.Fatalf("tick %v should remove only its own key, got %v", tick, removed)
+		}
+	}
+	if e.count() != 0 {
+		t.Fatalf("every key should be removed, %v are left", e.count())
+	}
+}
+
+func TestCacheParallelGet(t *testing.T) {
+	c := newTestCache[int](1000)
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprintf("key-%v", i), i, time.Minute)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := fmt.Sprintf("key-%v", (i+g)%100)
+				if val, err := c.Get(key); err != nil || val != (i+g)%100 {
+					t.Errorf("expected value %v for %v, got %v %v", (i+g)%100, key, val, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	/** process() is stopped after close, the reads are dropped and Get still return at once */
+	c.Close()
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 1000; i++ {
+		c.Get("key-1")
+	}
+	if drops := c.Stats().FreqBatchDrops; drops == 0 {
+		t.Fatalf("reads should be dropped when process() does not take them")
+	}
+}
+
+func TestCacheShards(t *testing.T) {
+	for _, shards := range []int{1, 5, 64} {
+		c := NewCacheWithCapacity[int](&CacheConfig[string, int]{
+			Capacity:   2000,
+			CountBatch: 5,
+			FreqCount:  100,
+			Shards:     shards,
+		}, make(chan int))
+		for i := 0; i < 1000; i++ {
+			c.Set(fmt.Sprintf("key-%v", i), i, time.Minute)
+		}
+		if val, err := c.Get("key-500"); err != nil || val != 500 {
+			t.Fatalf("expected value 500 with %v shards, got %v %v", shards, val, err)
+		}
+		/** the count is rounded up to a power of two */
+		sizes := c.Stats().ShardSizes
+		expected := map[int]int{1: 1, 5: 8, 64: 64}[shards]
+		if len(sizes) != expected {
+			t.Fatalf("%v shards should be rounded to %v, got %v", shards, expected, len(sizes))
+		}
+		total := 0
+		for i, size := range sizes {
+			if size == 0 {
+				t.Fatalf("shard %v of %v is empty, keys are not spread", i, len(sizes))
+			}
+			total += size
+		}
+		if total != 1000 {
+			t.Fatalf("shards should have 1000 keys, got %v", total)
+		}
+		c.Close()
+	}
+}
+
+func TestCacheCountMin(t *testing.T) {
+	/** width is e/0.01 rounded up to a power of two and depth is ln(1/0.01) */
+	cm := newCountMin(0.01, 0.99)
+	if cm.width() != 512 || cm.depth() != 5 {
+		t.Fatalf("expected width 512 and depth 5, got %v and %v", cm.width(), cm.depth())
+	}
+	hot, warm := keyHash("hot"), keyHash("warm")
+	for i := 0; i < 20; i++ {
+		cm.setKeyCount(hot)
+	}
+	for i := 0; i < 6; i++ {
+		cm.setKeyCount(warm)
+	}
+	if count := cm.getKeyCount(hot); count != 15 {
+		t.Fatalf("counter should stop at 15, got %v", count)
+	}
+	if count := cm.getKeyCount(warm); count < 6 {
+		t.Fatalf("estimate can not be less than the count, got %v", count)
+	}
+	cm.halve()
+	if hotCount, warmCount := cm.getKeyCount(hot), cm.getKeyCount(warm); hotCount != 7 || warmCount < 3 || warmCount > hotCount {
+		t.Fatalf("halve should keep half of the counts, got %v and %v", hotCount, warmCount)
+	}
+
+	/** the policy age the sketch instead of wiping it, so the hot key keep part of its frequency */
+	p := NewLFUPolicy[string](100, 0, 0, 0).(*lfuPolicy[string])
+	for i := 0; i < 10; i++ {
+		p.OnAccess("hot", hot)
+	}
+	before := p.estimate(hot)
+	for i := uint64(0); i <= p.ageAt; i++ {
+		p.OnAccess(fmt.Sprintf("cold-%v", i), keyHash(fmt.Sprintf("cold-%v", i)))
+	}
+	if p.sketchResets() != 1 {
+		t.Fatalf("sketch should be aged once, got %v", p.sketchResets())
+	}
+	if after := p.estimate(hot); after == 0 || after >= before {
+		t.Fatalf("hot key frequency should be halved, got %v before and %v after", before, after)
+	}
+}
+
+func TestCacheSnapshot(t *testing.T) {
+	config := func() *CacheConfig[string, int] {
+		return &CacheConfig[string, int]{
+			Capacity:            100,
+			CountBatch:          5,
+			SnapshotFrequencies: true,
+		}
+	}
+	c := NewCacheWithCapacity[int](config(), make(chan int))
+	c.Set("a", 1, time.Hour)
+	c.Set("b", 2, NoExpiration)
+	c.Set("expired", 3, time.Millisecond)
+	c.SetWithIdle("idle", 4, time.Hour, 0)
+	for i := 0; i < 20; i++ {
+		c.Get("a")
+	}
+	/** the expired item need its ttl to pass, the reads of a are given to the policy at once */
+	time.Sleep(20 * time.Millisecond)
+	c.data.(*CacheData[string, int]).drainAccesses()
+	var b bytes.Buffer
+	if err := c.Snapshot(&b); err != nil {
+		t.Fatalf("snapshot failed %v", err)
+	}
+	freq, _ := c.data.Frequency("a", c.hash("a"))
+	c.Close()
+
+	r := NewCacheWithCapacity[int](config(), make(chan int))
+	defer r.Close()
+	if err := r.Restore(&b); err != nil {
+		t.Fatalf("restore failed %v", err)
+	}
+	for key, value := range map[string]int{"a": 1, "b": 2, "idle": 4} {
+		if val, err := r.Get(key); err != nil || val != value {
+			t.Fatalf("expected value %v for %v, got %v %v", value, key, val, err)
+		}
+	}
+	if _, err := r.Get("expired"); err == nil {
+		t.Fatalf("expired item should not be saved")
+	}
+	data := r.data.(*CacheData[string, int])
+	item, _, _ := data.Get("a", r.hash("a"))
+	if ttl := time.Until(item.expiration); ttl <= 50*time.Minute || ttl > time.Hour {
+		t.Fatalf("restored item should keep its remaining ttl, got %v", ttl)
+	}
+	if item.ttl != time.Hour {
+		t.Fatalf("restored item should keep the ttl it was set with for a refresh, got %v", item.ttl)
+	}
+	if item, _, _ := data.Get("b", r.hash("b")); item.ttl != NoExpiration || !item.expiration.IsZero() {
+		t.Fatalf("item without expiration should be restored without it, got %v %v", item.ttl, item.expiration)
+	}
+	if restored, _ := r.data.Frequency("a", r.hash("a")); freq < 2 || restored < freq {
+		t.Fatalf("frequency should be restored, saved %v restored %v", freq, restored)
+	}
+
+	/** with a path the cache is saved on Close and restored by the constructor */
+	path := t.TempDir() + "/cache.snapshot"
+	pathConfig := config()
+	pathConfig.SnapshotPath = path
+	c = NewCacheWithCapacity[int](pathConfig, make(chan int))
+	c.Set("saved", 5, time.Hour)
+	c.Close()
+	c = NewCacheWithCapacity[int](pathConfig, make(chan int))
+	defer c.Close()
+	if val, err := c.Get("saved"); err != nil || val != 5 {
+		t.Fatalf("item should be restored from the snapshot file, got %v %v", val, err)
+	}
+
+	/** a broken snapshot set no item, and the error of the file is given to OnSnapshotError */
+	var full bytes.Buffer
+	if err := c.Snapshot(&full); err != nil {
+		t.Fatalf("snapshot failed %v", err)
+	}
+	broken := full.Bytes()[:full.Len()-3]
+	pc := NewCacheWithCapacity[int](config(), make(chan int))
+	defer pc.Close()
+	if err := pc.Restore(bytes.NewReader(broken)); err == nil {
+		t.Fatalf("restore of a broken snapshot should fail")
+	}
+	if _, err := pc.Get("saved"); err == nil || pc.Len() != 0 {
+		t.Fatalf("failed restore should not set any item, %v items", pc.Len())
+	}
+	brokenPath := t.TempDir() + "/broken.snapshot"
+	if err := os.WriteFile(brokenPath, broken, 0644); err != nil {
+		t.Fatalf("failed to write the snapshot %v", err)
+	}
+	var snapshotErr error
+	brokenConfig := config()
+	brokenConfig.SnapshotPath = brokenPath
+	brokenConfig.OnSnapshotError = func(err error) {
+		snapshotErr = err
+	}
+	bc := NewCacheWithCapacity[int](brokenConfig, make(chan int))
+	defer bc.Close()
+	if snapshotErr == nil || bc.Len() != 0 {
+		t.Fatalf("restore error should be reported and the cache left empty, got %v", snapshotErr)
+	}
+}
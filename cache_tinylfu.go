@@ -0,0 +1,277 @@
+package cache
+
+import (
+	"container/list"
+	"math"
+	"sync/atomic"
+
+	bbloom "github.com/amitiwary999/go-cache/internal/bloom"
+	cacheheap "github.com/amitiwary999/go-cache/internal/heap"
+)
+
+/** W-TinyLFU. A new key first goes in a small LRU window. When the window is full the oldest key of the
+window is the candidate for the main (LFU) part. If the cache has no space the candidate is only admitted
+when its frequency is more than the frequency of the main victim, otherwise the candidate is dropped.
+So one scan of cold keys only churn the window and never flush the hot keys of main.
+The doorkeeper is a bloom filter in front of the sketch. The first access of a key only set the doorkeeper,
+so the keys seen once never reach the sketch counters. */
+
+const windowPercent = 1
+
+type windowItem[K comparable] struct {
+	key  K
+	hash uint64
+	cost int64
+}
+
+type admissionWindow[K comparable] struct {
+	items    *list.List
+	itemsMap map[K]*list.Element
+	cost     int64
+	capacity uint64
+	maxCost  int64
+}
+
+func newAdmissionWindow[K comparable](capacity uint64, maxCost int64) *admissionWindow[K] {
+	w := &admissionWindow[K]{
+		items:    list.New(),
+		itemsMap: make(map[K]*list.Element),
+	}
+	if capacity > 0 {
+		w.capacity = max(1, capacity*windowPercent/100)
+	}
+	if maxCost > 0 {
+		w.maxCost = max(1, maxCost*windowPercent/100)
+	}
+	return w
+}
+
+func (w *admissionWindow[K]) add(key K, hash uint64, cost int64) {
+	if _, ok := w.itemsMap[key]; ok {
+		return
+	}
+	w.itemsMap[key] = w.items.PushFront(&windowItem[K]{key: key, hash: hash, cost: cost})
+	w.cost += cost
+}
+
+func (w *admissionWindow[K]) access(key K) {
+	if e, ok := w.itemsMap[key]; ok {
+		w.items.MoveToFront(e)
+	}
+}
+
+func (w *admissionWindow[K]) remove(key K) bool {
+	e, ok := w.itemsMap[key]
+	if !ok {
+		return false
+	}
+	w.items.Remove(e)
+	delete(w.itemsMap, key)
+	w.cost -= e.Value.(*windowItem[K]).cost
+	return true
+}
+
+func (w *admissionWindow[K]) full() bool {
+	if w.items.Len() <= 1 {
+		return false
+	}
+	if w.capacity > 0 && uint64(w.items.Len()) > w.capacity {
+		return true
+	}
+	return w.maxCost > 0 && w.cost > w.maxCost
+}
+
+func (w *admissionWindow[K]) pop() *windowItem[K] {
+	e := w.items.Back()
+	if e == nil {
+		return nil
+	}
+	item := e.Value.(*windowItem[K])
+	w.remove(item.key)
+	return item
+}
+
+func newDoorkeeper(entries uint64) *bbloom.Bloom {
+	return bbloom.NewBloomFilter(float64(entries), 0.01)
+}
+
+type lfuPolicy[K comparable] struct {
+	sketch     countmin
+	doorkeeper *bbloom.Bloom
+	window     *admissionWindow[K]
+	/** keys that left the window and are not yet admitted to main */
+	candidates  []*windowItem[K]
+	queue       PriorityQueue[K]
+	queueItems  map[K]*LFUItem[K]
+	accessCount uint64
+	ageAt       uint64
+	ages        uint64
+}
+
+const (
+	defaultSketchConfidence = 0.99
+	/** the sketch is aged after this many accesses per counter of a row */
+	sketchSamplePerCounter = 10
+)
+
+// NewLFUPolicy is the W-TinyLFU policy, the default policy of the cache. The window is sized from the capacity
+// and max cost of the cache. The sketch is sized from sketchError and sketchConfidence, see CacheConfig,
+// zero sketchError means one counter per key of the cache and zero confidence means 0.99.
+func NewLFUPolicy[K comparable](capacity uint64, maxCost int64, sketchError float64, sketchConfidence float64) EvictionPolicy[K] {
+	if sketchError <= 0 {
+		/** Cache bound only by cost has no capacity, then every unit of cost is counted as a key */
+		keys := capacity
+		if keys == 0 {
+			keys = uint64(max(maxCost, 0))
+		}
+		sketchError = math.E / float64(max(keys, minSketchWidth))
+	}
+	if sketchConfidence <= 0 || sketchConfidence >= 1 {
+		sketchConfidence = defaultSketchConfidence
+	}
+	p := &lfuPolicy[K]{
+		sketch:     *newCountMin(sketchError, sketchConfidence),
+		window:     newAdmissionWindow[K](capacity, maxCost),
+		queue:      make(PriorityQueue[K], 0),
+		queueItems: make(map[K]*LFUItem[K]),
+	}
+	p.ageAt = sketchSamplePerCounter * uint64(p.sketch.width())
+	p.doorkeeper = newDoorkeeper(p.ageAt)
+	cacheheap.Init(&p.queue)
+	return p
+}
+
+func (p *lfuPolicy[K]) increment(hash uint64) {
+	if !p.doorkeeper.Has(hash) {
+		p.doorkeeper.Add(hash)
+		return
+	}
+	p.sketch.setKeyCount(hash)
+}
+
+func (p *lfuPolicy[K]) estimate(hash uint64) uint64 {
+	freq := p.sketch.getKeyCount(hash)
+	if p.doorkeeper.Has(hash) {
+		freq++
+	}
+	return freq
+}
+
+func (p *lfuPolicy[K]) OnAccess(key K, hash uint64) {
+	p.increment(hash)
+	if item, ok := p.queueItems[key]; ok {
+		p.queue.update(item, p.estimate(hash))
+		cacheheap.Fix(&p.queue, item.index)
+	} else {
+		p.window.access(key)
+	}
+	p.accessCount++
+	if p.accessCount > p.ageAt {
+		p.age()
+	}
+}
+
+// OnInsert put the new key in the window. The candidates left from the last insert did not have to
+// compete with main, the cache had space for them, so they are moved to main first.
+func (p *lfuPolicy[K]) OnInsert(key K, hash uint64, cost int64) {
+	for _, candidate := range p.candidates {
+		p.push(candidate)
+	}
+	p.candidates = p.candidates[:0]
+	p.window.add(key, hash, cost)
+	for p.window.full() {
+		p.candidates = append(p.candidates, p.window.pop())
+	}
+}
+
+func (p *lfuPolicy[K]) push(candidate *windowItem[K]) {
+	item := &LFUItem[K]{
+		key:  candidate.key,
+		hash: candidate.hash,
+		freq: p.estimate(candidate.hash),
+	}
+	cacheheap.Push(&p.queue, item)
+	p.queueItems[candidate.key] = item
+}
+
+func (p *lfuPolicy[K]) OnRemove(key K) {
+	if p.window.remove(key) {
+		return
+	}
+	if item, ok := p.queueItems[key]; ok {
+		cacheheap.Remove(&p.queue, item.index)
+		delete(p.queueItems, key)
+		return
+	}
+	for i, candidate := range p.candidates {
+		if candidate.key == key {
+			p.candidates = append(p.candidates[:i], p.candidates[i+1:]...)
+			return
+		}
+	}
+}
+
+// Victim let the candidate compete with the least frequent key of main, the winner goes to main and the
+// loser is the victim. Without candidates the least frequent key of main is the victim, and when main is
+// empty because everything is in the window it is the oldest key of the window.
+func (p *lfuPolicy[K]) Victim() (K, bool) {
+	if len(p.candidates) > 0 {
+		candidate := p.candidates[0]
+		p.candidates = p.candidates[1:]
+		if p.queue.Len() == 0 || p.estimate(candidate.hash) <= p.estimate(p.queue[0].hash) {
+			return candidate.key, true
+		}
+		victim := p.pop()
+		p.push(candidate)
+		return victim.key, true
+	}
+	if p.queue.Len() > 0 {
+		return p.pop().key, true
+	}
+	if item := p.window.pop(); item != nil {
+		return item.key, true
+	}
+	var key K
+	return key, false
+}
+
+func (p *lfuPolicy[K]) pop() *LFUItem[K] {
+	item := cacheheap.Pop(&p.queue).(*LFUItem[K])
+	delete(p.queueItems, item.key)
+	return item
+}
+
+// age halve all the frequencies, so the keys that were hot long ago slowly lose to the keys hot now. The
+// doorkeeper is cleared, a key has to be seen again before it reach the sketch.
+func (p *lfuPolicy[K]) age() {
+	p.sketch.halve()
+	p.doorkeeper.Clear()
+	p.queue.halve()
+	p.accessCount = 0
+	atomic.AddUint64(&p.ages, 1)
+}
+
+func (p *lfuPolicy[K]) Reset() {
+	p.queue.reset()
+	p.sketch.reset()
+	p.doorkeeper.Clear()
+}
+
+func (p *lfuPolicy[K]) frequency(hash uint64) uint64 {
+	return p.estimate(hash)
+}
+
+// setFrequency count the key till its estimate reach freq. The doorkeeper take the first count.
+func (p *lfuPolicy[K]) setFrequency(key K, hash uint64, freq uint64) {
+	for i := uint64(0); i < min(freq, counterMax+1) && p.estimate(hash) < freq; i++ {
+		p.increment(hash)
+	}
+	if item, ok := p.queueItems[key]; ok {
+		p.queue.update(item, p.estimate(hash))
+		cacheheap.Fix(&p.queue, item.index)
+	}
+}
+
+func (p *lfuPolicy[K]) sketchResets() uint64 {
+	return atomic.LoadUint64(&p.ages)
+}
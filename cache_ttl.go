@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	/** ttl for the item that never expire */
+	NoExpiration time.Duration = -1
+	/** ttl zero means the DefaultTTL of the cache config */
+	DefaultExpiration time.Duration = 0
+)
+
+type ttlPolicy struct {
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+	jitter     float64
+}
+
+// expiration return the expiration time of an item set now with the ttl, zero time if it never expire.
+// The jitter only shorten the ttl, so an item never live more than its ttl or the MaxTTL. It spread the
+// items that are set together over several expiration buckets, so they don't expire in one burst.
+func (p ttlPolicy) expiration(now time.Time, ttl time.Duration) time.Time {
+	if ttl == DefaultExpiration {
+		ttl = p.defaultTTL
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	if p.maxTTL > 0 && ttl > p.maxTTL {
+		ttl = p.maxTTL
+	}
+	if p.jitter > 0 {
+		ttl -= time.Duration(rand.Float64() * min(p.jitter, 1) * float64(ttl))
+	}
+	return now.Add(ttl)
+}
@@ -9,7 +9,7 @@ import (
 
 func main() {
 	done := make(chan int)
-	nch := cache.NewCacheWithCapacity[int](&cache.CacheConfig{
+	nch := cache.NewCacheWithCapacity(&cache.CacheConfig[string, int]{
 		Capacity:   20,
 		CountBatch: 5,
 		FreqCount:  10,
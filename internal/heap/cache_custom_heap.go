@@ -68,3 +68,14 @@ func down(h Interface, i0, n int) (bool, int) {
 	}
 	return i > i0, i
 }
+
+func Remove(h Interface, i int) any {
+	n := h.Len() - 1
+	if n != i {
+		h.Swap(i, n)
+		if downF, _ := down(h, i, n); !downF {
+			up(h, i)
+		}
+	}
+	return h.Pop()
+}
@@ -0,0 +1,211 @@
+// Package metrics export the stats of the caches in Prometheus text format and through expvar.
+// It works with anything that has Stats(), so Cache, KeyCache, the ring cache and the big ring cache
+// can all be registered in the same exporter with a name to tell them apart.
+package metrics
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	cache "github.com/amitiwary999/go-cache"
+)
+
+type StatsSource interface {
+	Stats() cache.Stats
+}
+
+type Exporter struct {
+	sync.RWMutex
+	caches map[string]StatsSource
+}
+
+type metric struct {
+	name   string
+	help   string
+	kind   string
+	label  string
+	values func(cache.Stats) []labeledValue
+}
+
+type labeledValue struct {
+	label string
+	value float64
+}
+
+func single(f func(cache.Stats) float64) func(cache.Stats) []labeledValue {
+	return func(s cache.Stats) []labeledValue {
+		return []labeledValue{{value: f(s)}}
+	}
+}
+
+var metrics = []metric{
+	{
+		name:   "go_cache_hits_total",
+		help:   "Number of reads that found a live item.",
+		kind:   "counter",
+		values: single(func(s cache.Stats) float64 { return float64(s.Hits) }),
+	},
+	{
+		name:   "go_cache_misses_total",
+		help:   "Number of reads that found no live item.",
+		kind:   "counter",
+		values: single(func(s cache.Stats) float64 { return float64(s.Misses) }),
+	},
+	{
+		name:   "go_cache_expired_reads_total",
+		help:   "Number of reads that found an expired item.",
+		kind:   "counter",
+		values: single(func(s cache.Stats) float64 { return float64(s.ExpiredOnRead) }),
+	},
+	{
+		name:   "go_cache_stale_hits_total",
+		help:   "Number of reads that returned an expired value in the stale window.",
+		kind:   "counter",
+		values: single(func(s cache.Stats) float64 { return float64(s.StaleHits) }),
+	},
+	{
+		name:  "go_cache_refreshes_total",
+		help:  "Number of background reloads by result.",
+		kind:  "counter",
+		label: "result",
+		values: func(s cache.Stats) []labeledValue {
+			return []labeledValue{
+				{label: "success", value: float64(s.Refreshes)},
+				{label: "failure", value: float64(s.RefreshFailures)},
+			}
+		},
+	},
+	{
+		name:  "go_cache_removals_total",
+		help:  "Number of items removed from the cache by reason.",
+		kind:  "counter",
+		label: "reason",
+		values: func(s cache.Stats) []labeledValue {
+			return []labeledValue{
+				{label: cache.RemovalEvicted.String(), value: float64(s.Evictions)},
+				{label: cache.RemovalExpired.String(), value: float64(s.Expirations)},
+				{label: cache.RemovalDeleted.String(), value: float64(s.Deletions)},
+				{label: cache.RemovalReplaced.String(), value: float64(s.Replacements)},
+			}
+		},
+	},
+	{
+		name:   "go_cache_size",
+		help:   "Number of items in the cache.",
+		kind:   "gauge",
+		values: single(func(s cache.Stats) float64 { return float64(s.Size) }),
+	},
+	{
+		name:   "go_cache_cost",
+		help:   "Total cost of the items in the cache.",
+		kind:   "gauge",
+		values: single(func(s cache.Stats) float64 { return float64(s.Cost) }),
+	},
+	{
+		name:   "go_cache_freq_batch_drops_total",
+		help:   "Number of frequency batches dropped because the counting goroutine was busy.",
+		kind:   "counter",
+		values: single(func(s cache.Stats) float64 { return float64(s.FreqBatchDrops) }),
+	},
+	{
+		name:   "go_cache_sketch_resets_total",
+		help:   "Number of times the frequency sketch was aged by halving its counters.",
+		kind:   "counter",
+		values: single(func(s cache.Stats) float64 { return float64(s.SketchResets) }),
+	},
+	{
+		name:  "go_cache_shard_size",
+		help:  "Number of items in each shard of the cache.",
+		kind:  "gauge",
+		label: "shard",
+		values: func(s cache.Stats) []labeledValue {
+			values := make([]labeledValue, len(s.ShardSizes))
+			for i, size := range s.ShardSizes {
+				values[i] = labeledValue{label: strconv.Itoa(i), value: float64(size)}
+			}
+			return values
+		},
+	},
+}
+
+func NewExporter() *Exporter {
+	return &Exporter{
+		caches: make(map[string]StatsSource),
+	}
+}
+
+func (e *Exporter) Register(name string, c StatsSource) error {
+	if name == "" {
+		return errors.New("cache name is empty")
+	}
+	e.Lock()
+	defer e.Unlock()
+	if _, ok := e.caches[name]; ok {
+		return fmt.Errorf("cache %v is already registered", name)
+	}
+	e.caches[name] = c
+	return nil
+}
+
+func (e *Exporter) Unregister(name string) {
+	e.Lock()
+	defer e.Unlock()
+	delete(e.caches, name)
+}
+
+func (e *Exporter) snapshot() ([]string, map[string]cache.Stats) {
+	e.RLock()
+	defer e.RUnlock()
+	names := make([]string, 0, len(e.caches))
+	stats := make(map[string]cache.Stats, len(e.caches))
+	for name, c := range e.caches {
+		names = append(names, name)
+		stats[name] = c.Stats()
+	}
+	sort.Strings(names)
+	return names, stats
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (e *Exporter) WritePrometheus(w io.Writer) error {
+	names, stats := e.snapshot()
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", m.name, m.help, m.name, m.kind); err != nil {
+			return err
+		}
+		for _, name := range names {
+			for _, v := range m.values(stats[name]) {
+				labels := fmt.Sprintf(`cache="%v"`, labelEscaper.Replace(name))
+				if m.label != "" {
+					labels += fmt.Sprintf(`,%v="%v"`, m.label, labelEscaper.Replace(v.label))
+				}
+				if _, err := fmt.Fprintf(w, "%v{%v} %v\n", m.name, labels, v.value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	e.WritePrometheus(w)
+}
+
+// PublishExpvar publish the stats of all registered caches as one expvar map keyed by cache name.
+// Like expvar.Publish it panics if the name is already used.
+func (e *Exporter) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		_, stats := e.snapshot()
+		return stats
+	}))
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cache "github.com/amitiwary999/go-cache"
+)
+
+type fakeCache struct {
+	stats cache.Stats
+}
+
+func (f fakeCache) Stats() cache.Stats {
+	return f.stats
+}
+
+func TestExporterPrometheus(t *testing.T) {
+	e := NewExporter()
+	e.Register("users", fakeCache{stats: cache.Stats{Hits: 3, Misses: 1, Evictions: 2, Size: 5, ShardSizes: []int{2, 3}}})
+	e.Register("orders", fakeCache{stats: cache.Stats{Hits: 7}})
+	if err := e.Register("users", fakeCache{}); err == nil {
+		t.Fatalf("registering the same name twice should fail")
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE go_cache_hits_total counter",
+		`go_cache_hits_total{cache="users"} 3`,
+		`go_cache_hits_total{cache="orders"} 7`,
+		`go_cache_removals_total{cache="users",reason="evicted"} 2`,
+		`go_cache_size{cache="users"} 5`,
+		`go_cache_shard_size{cache="users",shard="1"} 3`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("metrics output has no line %q\n%v", line, body)
+		}
+	}
+}
+
+func TestExporterCaches(t *testing.T) {
+	c := cache.NewCacheWithCapacity[int](&cache.CacheConfig[string, int]{
+		Capacity:   10,
+		CountBatch: 5,
+		FreqCount:  100,
+	}, make(chan int))
+	defer c.Close()
+	r := cache.NewCacheRing[int](10, cache.RingClock)
+
+	e := NewExporter()
+	e.Register("cache", c)
+	e.Register("ring", r)
+	c.Set("a", 1, 0)
+	r.Set("a", 1)
+	r.Get("a")
+	r.Get("b")
+
+	var b strings.Builder
+	e.WritePrometheus(&b)
+	if !strings.Contains(b.String(), `go_cache_hits_total{cache="ring"} 1`) {
+		t.Fatalf("ring cache hits not exported\n%v", b.String())
+	}
+	if !strings.Contains(b.String(), `go_cache_size{cache="cache"} 1`) {
+		t.Fatalf("cache size not exported\n%v", b.String())
+	}
+
+	/** the ring is written by one goroutine while it is scraped, the size is read without touching the ring */
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			r.Set(fmt.Sprintf("key-%v", i), i)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		e.WritePrometheus(io.Discard)
+	}
+	<-done
+	if size := r.Stats().Size; size != 10 {
+		t.Fatalf("expected ring size 10, got %v", size)
+	}
+}
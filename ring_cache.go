@@ -7,22 +7,39 @@ import (
 	xxhash "github.com/cespare/xxhash/v2"
 )
 
+// RingPolicy is the replacement algorithm of cacheRing.
+type RingPolicy int
+
+const (
+	/** CLOCK put the new item at the hand, so the ring is not kept in insert order */
+	RingClock RingPolicy = iota
+	/** SIEVE keep the ring in insert order, the hand only evict and the new item always goes after the newest one */
+	RingSieve
+)
+
 type cacheRing[T any] struct {
-	data map[uint64]*ring.Ring
-	hand *ring.Ring
+	data   map[uint64]*ring.Ring
+	hand   *ring.Ring
+	stats  ringStats
+	policy RingPolicy
+	/** newest item of SIEVE, its next item in the ring is the oldest one */
+	newest *ring.Ring
 }
 
 type cacheRingItem[T any] struct {
 	key       uint64
+	keyString string
 	item      T
 	reference int8
 }
 
-func NewCacheRing[T any](capacity int32) *cacheRing[T] {
+func NewCacheRing[T any](capacity int32, policy RingPolicy) *cacheRing[T] {
 	r := ring.New(int(capacity))
 	return &cacheRing[T]{
-		data: make(map[uint64]*ring.Ring),
-		hand: r,
+		data:   make(map[uint64]*ring.Ring),
+		hand:   r,
+		policy: policy,
+		newest: r.Prev(),
 	}
 }
 
@@ -35,7 +52,31 @@ func (c *cacheRing[T]) findReplaceItem() {
 		currentItem := c.hand.Value.(*cacheRingItem[T])
 		delete(c.data, currentItem.key)
 		c.hand.Value = nil
+		c.stats.addRemoval(RemovalEvicted)
+	}
+}
+
+// findSieveSlot move the hand from the oldest to the newest item, clear the reference of the items it pass
+// and stop at the first item with no reference or at an empty slot. The item at the hand is evicted and its
+// slot is moved after the newest item, the hand stay where the slot was.
+func (c *cacheRing[T]) findSieveSlot() *ring.Ring {
+	for c.hand.Value != nil && c.hand.Value.(*cacheRingItem[T]).reference == 1 {
+		c.hand.Value.(*cacheRingItem[T]).reference = 0
+		c.hand = c.hand.Next()
+	}
+	slot := c.hand
+	if slot.Value != nil {
+		delete(c.data, slot.Value.(*cacheRingItem[T]).key)
+		slot.Value = nil
+		c.stats.addRemoval(RemovalEvicted)
+	}
+	c.hand = slot.Next()
+	if slot != c.newest && slot != c.newest.Next() {
+		slot.Prev().Unlink(1)
+		c.newest.Link(slot)
 	}
+	c.newest = slot
+	return slot
 }
 
 func (c *cacheRing[T]) Set(key string, value T) error {
@@ -45,15 +86,25 @@ func (c *cacheRing[T]) Set(key string, value T) error {
 	if ok {
 		cacheItem := ringVal.Value.(*cacheRingItem[T])
 		cacheItem.key = keyInt
+		cacheItem.keyString = key
 		cacheItem.item = value
 		cacheItem.reference = 0
+		c.stats.addRemoval(RemovalReplaced)
 		return nil
 	}
 	item := &cacheRingItem[T]{
 		key:       keyInt,
+		keyString: key,
 		item:      value,
 		reference: 0,
 	}
+	if c.policy == RingSieve {
+		slot := c.findSieveSlot()
+		slot.Value = item
+		c.data[keyInt] = slot
+		c.stats.setSize(len(c.data))
+		return nil
+	}
 	if c.hand.Value == nil {
 		c.hand.Value = item
 		c.data[keyInt] = c.hand
@@ -63,6 +114,7 @@ func (c *cacheRing[T]) Set(key string, value T) error {
 		c.data[keyInt] = c.hand
 	}
 	c.hand = c.hand.Next()
+	c.stats.setSize(len(c.data))
 	return nil
 }
 
@@ -74,8 +126,10 @@ func (c *cacheRing[T]) Get(key string) (T, error) {
 	if ok {
 		cacheItem := ringVal.Value.(*cacheRingItem[T])
 		cacheItem.reference = 1
+		c.stats.hit()
 		return cacheItem.item, nil
 	} else {
+		c.stats.miss()
 		return itemVal, errors.New("key not found")
 	}
 }
@@ -84,14 +138,38 @@ func (c *cacheRing[T]) Delete(key string) error {
 	keyByte := []byte(key)
 	keyInt := xxhash.Sum64(keyByte)
 	ringVal, ok := c.data[keyInt]
+	if ok && c.policy == RingSieve {
+		c.deleteSieveSlot(ringVal)
+		delete(c.data, keyInt)
+		c.stats.setSize(len(c.data))
+		c.stats.addRemoval(RemovalDeleted)
+		return nil
+	}
 	if ok {
 		prevHand := ringVal.Prev()
 		nextHand := ringVal.Next()
 		prevHand.Link(nextHand)
 		ringVal.Value = nil
 		delete(c.data, keyInt)
+		c.stats.setSize(len(c.data))
+		c.stats.addRemoval(RemovalDeleted)
 		return nil
 	} else {
 		return errors.New("key not found")
 	}
 }
+
+// deleteSieveSlot empty the slot and move it to the hand, so the ring keep its size and the next Set use
+// the slot before the hand evict anything.
+func (c *cacheRing[T]) deleteSieveSlot(slot *ring.Ring) {
+	slot.Value = nil
+	if slot == c.hand {
+		return
+	}
+	if slot == c.newest {
+		c.newest = slot.Prev()
+	}
+	slot.Prev().Unlink(1)
+	c.hand.Prev().Link(slot)
+	c.hand = slot
+}